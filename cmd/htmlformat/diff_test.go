@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	numbers := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20\n"
+
+	tests := []struct {
+		name     string
+		a        string
+		b        string
+		expected string
+	}{
+		{
+			name:     "equal input writes nothing",
+			a:        "x\ny\n",
+			b:        "x\ny\n",
+			expected: "",
+		},
+		{
+			name: "inserted line",
+			a:    "x\n",
+			b:    "x\ny\n",
+			expected: `--- a
++++ b
+@@ -1 +1,2 @@
+ x
++y
+`,
+		},
+		{
+			name: "deleted line",
+			a:    "x\ny\n",
+			b:    "y\n",
+			expected: `--- a
++++ b
+@@ -1,2 +1 @@
+-x
+ y
+`,
+		},
+		{
+			name: "insertion into empty input",
+			a:    "",
+			b:    "x\n",
+			expected: `--- a
++++ b
+@@ -0,0 +1 @@
++x
+`,
+		},
+		{
+			name: "missing final line ending",
+			a:    "x\n",
+			b:    "x",
+			expected: `--- a
++++ b
+@@ -1 +1 @@
+-x
++x
+\ No newline at end of file
+`,
+		},
+		{
+			name: "changes close together share a hunk and distant ones get their own",
+			a:    numbers,
+			b:    strings.NewReplacer("\n2\n", "\ntwo\n", "\n9\n", "\nnine\n", "\n18\n", "\n").Replace(numbers),
+			expected: `--- a
++++ b
+@@ -1,12 +1,12 @@
+ 1
+-2
++two
+ 3
+ 4
+ 5
+ 6
+ 7
+ 8
+-9
++nine
+ 10
+ 11
+ 12
+@@ -15,6 +15,5 @@
+ 15
+ 16
+ 17
+-18
+ 19
+ 20
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, unifiedDiff(&b, "a", "b", []byte(test.a), []byte(test.b)))
+			assert.Equal(t, test.expected, b.String())
+		})
+	}
+}
@@ -1,25 +1,267 @@
 package main
 
 import (
+	"bytes"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/asartalo/formathtml"
 )
 
 var parseDocumentFlag = flag.Bool("document", false, "Set to true to parse a whole document")
+var parseFragmentFlag = flag.Bool("fragment", false, "Set to true to parse a fragment of a document; the kind of input is detected when neither this nor -document is set")
+var writeFlag = flag.Bool("w", false, "Write the result to each named file instead of stdout")
+var diffFlag = flag.Bool("d", false, "Print a unified diff of the changes instead of the formatted output, or as well as the names reported by -check and -l")
+var checkFlag = flag.Bool("check", false, "Report files that are not formatted and exit with status 1 without writing")
+var listFlag = flag.Bool("l", false, "List files whose formatting differs from the formatted output")
+var extFlag = flag.String("ext", ".html,.htm", "Comma separated extensions of the files formatted when walking a directory")
+var jsonFlag = flag.Bool("json", false, "Print a JSON report of which files change, and any errors, instead of the formatted output")
+var skipFlag = flag.String("skip", "", "Comma separated names of more directories to skip when walking a directory")
+
+// Directories that are never walked into.
+var defaultSkippedDirs = []string{".git", "node_modules"}
 
 func main() {
 	flag.Parse()
 
-	var err error
-	if *parseDocumentFlag {
-		err = formathtml.Document(os.Stdout, os.Stdin)
+	if *parseDocumentFlag && *parseFragmentFlag {
+		log.Fatal("cannot use -document with -fragment")
+	}
+
+	if *jsonFlag {
+		if err := writeReport(os.Stdout, flag.Args()); err != nil {
+			log.Fatalf("failed to write report: %v", err)
+		}
+		return
+	}
+
+	if flag.NArg() == 0 {
+		if *checkFlag || *listFlag {
+			src, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("failed to read: %v", err)
+			}
+			if !check(os.Stdout, os.Stderr, "<standard input>", src) && *checkFlag {
+				os.Exit(1)
+			}
+			return
+		}
+		if *writeFlag {
+			log.Fatal("cannot use -w with standard input")
+		}
+		if *diffFlag {
+			if err := diffFile("<standard input>", os.Stdin); err != nil {
+				log.Fatalf("failed to format: %v", err)
+			}
+			return
+		}
+		if err := format(os.Stdout, os.Stdin); err != nil {
+			log.Fatalf("failed to format: %v", err)
+		}
+		return
+	}
+
+	formatted := true
+	for _, arg := range flag.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !info.IsDir() {
+			formatted = processFile(arg) && formatted
+			continue
+		}
+
+		paths, err := walkDir(arg)
+		if err != nil {
+			log.Fatalf("failed to walk %s: %v", arg, err)
+		}
+		for _, path := range paths {
+			formatted = processFile(path) && formatted
+		}
+	}
+	if !formatted {
+		os.Exit(1)
+	}
+}
+
+// Formats, checks or lists the file depending on the flags and reports
+// whether it was already formatted when checking.
+func processFile(path string) bool {
+	if *checkFlag || *listFlag {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", path, err)
+		}
+		return check(os.Stdout, os.Stderr, path, src) || !*checkFlag
+	}
+
+	if err := formatFile(path); err != nil {
+		log.Fatalf("failed to format %s: %v", path, err)
+	}
+	return true
+}
+
+// Returns the paths of the files under root with one of the -ext extensions,
+// leaving out the directories named by -skip and the default skipped ones.
+func walkDir(root string) ([]string, error) {
+	exts := splitList(*extFlag)
+	skip := map[string]bool{}
+	for _, name := range append(splitList(*skipFlag), defaultSkippedDirs...) {
+		skip[name] = true
+	}
+
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && skip[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := filepath.Ext(path)
+		for _, e := range exts {
+			if strings.EqualFold(ext, e) {
+				paths = append(paths, path)
+				break
+			}
+		}
+		return nil
+	})
+
+	return paths, err
+}
+
+// Splits a comma separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Reports whether src is already formatted. If not, name is printed to
+// stdout with -l or to stderr with -check, followed on stdout by a diff of
+// the changes with -d.
+func check(stdout, stderr io.Writer, name string, src []byte) bool {
+	var out bytes.Buffer
+	if err := format(&out, bytes.NewReader(src)); err != nil {
+		log.Fatalf("failed to format %s: %v", name, err)
+	}
+	if bytes.Equal(out.Bytes(), src) {
+		return true
+	}
+
+	if *listFlag {
+		fmt.Fprintln(stdout, name)
 	} else {
-		err = formathtml.Fragment(os.Stdout, os.Stdin)
+		fmt.Fprintln(stderr, name)
+	}
+	if *diffFlag {
+		if err := unifiedDiff(stdout, name+".orig", name, src, out.Bytes()); err != nil {
+			log.Fatalf("failed to write diff of %s: %v", name, err)
+		}
+	}
+
+	return false
+}
+
+// Prints a unified diff between the contents of r and its formatted version.
+func diffFile(name string, r io.Reader) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err = format(&out, bytes.NewReader(src)); err != nil {
+		return err
+	}
+
+	return unifiedDiff(os.Stdout, name+".orig", name, src, out.Bytes())
+}
+
+func format(w io.Writer, r io.Reader) error {
+	mode := formathtml.FragmentMode
+	switch {
+	case *parseDocumentFlag:
+		mode = formathtml.DocumentMode
+	case !*parseFragmentFlag:
+		var err error
+		if mode, r, err = formathtml.DetectMode(r); err != nil {
+			return err
+		}
 	}
+
+	if mode == formathtml.DocumentMode {
+		return formathtml.Document(w, r)
+	}
+
+	return formathtml.Fragment(w, r)
+}
+
+func formatFile(path string) error {
+	src, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("failed to format: %v", err)
+		return err
+	}
+
+	var out bytes.Buffer
+	if err = format(&out, bytes.NewReader(src)); err != nil {
+		return err
 	}
+
+	if *diffFlag {
+		return unifiedDiff(os.Stdout, path+".orig", path, src, out.Bytes())
+	}
+
+	if !*writeFlag {
+		_, err = os.Stdout.Write(out.Bytes())
+		return err
+	}
+
+	return writeFileAtomically(path, out.Bytes())
+}
+
+// Writes the contents to a temporary file next to path and renames it over
+// path so that the original is never left partially written.
+func writeFileAtomically(path string, contents []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
 }
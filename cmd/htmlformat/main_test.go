@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setFlag(t *testing.T, flag *bool, value bool) {
+	t.Helper()
+	old := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = old })
+}
+
+func TestCheckWithDiff(t *testing.T) {
+	setFlag(t, parseFragmentFlag, true)
+	setFlag(t, checkFlag, true)
+	setFlag(t, diffFlag, true)
+
+	var stdout, stderr strings.Builder
+	assert.False(t, check(&stdout, &stderr, "a.html", []byte("<div><p>x</p></div>\n")))
+	assert.Equal(t, "a.html\n", stderr.String())
+	assert.Equal(t, `--- a.html.orig
++++ a.html
+@@ -1 +1,3 @@
+-<div><p>x</p></div>
++<div>
++  <p>x</p>
++</div>
+`, stdout.String())
+
+	stdout.Reset()
+	stderr.Reset()
+	assert.True(t, check(&stdout, &stderr, "b.html", []byte("<p>x</p>\n")))
+	assert.Empty(t, stdout.String())
+	assert.Empty(t, stderr.String())
+}
+
+func TestListWithDiff(t *testing.T) {
+	setFlag(t, parseFragmentFlag, true)
+	setFlag(t, listFlag, true)
+	setFlag(t, diffFlag, true)
+
+	var stdout, stderr strings.Builder
+	assert.False(t, check(&stdout, &stderr, "a.html", []byte("<p>x</p>")))
+	assert.Empty(t, stderr.String())
+	assert.Equal(t, `a.html
+--- a.html.orig
++++ a.html
+@@ -1 +1 @@
+-<p>x</p>
+\ No newline at end of file
++<p>x</p>
+`, stdout.String())
+}
+
+func TestProcessFileCheckWithDiffFails(t *testing.T) {
+	setFlag(t, parseFragmentFlag, true)
+	setFlag(t, checkFlag, true)
+	setFlag(t, diffFlag, true)
+
+	path := filepath.Join(t.TempDir(), "a.html")
+	require.NoError(t, os.WriteFile(path, []byte("<div><p>x</p></div>\n"), 0o644))
+	assert.False(t, processFile(path), "an unformatted file makes -d -check exit with status 1")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// An entry of the -json report.
+type fileReport struct {
+	Path    string `json:"path"`
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Writes a JSON array with a report for each file named by the arguments, or
+// for standard input if there are none. Errors are reported for the file they
+// happen with and do not stop the others from being checked.
+func writeReport(w io.Writer, args []string) error {
+	reports := []fileReport{}
+	if len(args) == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		reports = append(reports, reportFile("<standard input>", src, err))
+	}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			reports = append(reports, fileReport{Path: arg, Error: err.Error()})
+			continue
+		}
+
+		paths := []string{arg}
+		if info.IsDir() {
+			if paths, err = walkDir(arg); err != nil {
+				reports = append(reports, fileReport{Path: arg, Error: err.Error()})
+				continue
+			}
+		}
+		for _, path := range paths {
+			src, err := os.ReadFile(path)
+			reports = append(reports, reportFile(path, src, err))
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(reports)
+}
+
+// Formats src and reports whether it changed, writing it back to path when
+// -w is set.
+func reportFile(path string, src []byte, readErr error) fileReport {
+	report := fileReport{Path: path}
+	if readErr != nil {
+		report.Error = readErr.Error()
+		return report
+	}
+
+	var out bytes.Buffer
+	if err := format(&out, bytes.NewReader(src)); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	report.Changed = !bytes.Equal(out.Bytes(), src)
+	if report.Changed && *writeFlag && path != "<standard input>" {
+		if err := writeFileAtomically(path, out.Bytes()); err != nil {
+			report.Error = err.Error()
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,63 @@
+package formathtml
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"golang.org/x/net/html"
+)
+
+// How many nodes are printed between checks of whether the context is done.
+const contextCheckInterval = 256
+
+// DocumentContext formats a HTML document like Document but stops with the
+// context's error once the context is done.
+func DocumentContext(ctx context.Context, w io.Writer, r io.Reader) error {
+	var src bytes.Buffer
+	node, err := html.ParseWithOptions(io.TeeReader(r, &src), html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return stageError(ParseStage, err)
+	}
+
+	nodes := []*html.Node{node}
+	f := newContextFormatter(ctx, DefaultOptions())
+	f.preserved = preservedSources(src.Bytes(), nodes)
+	return f.format(w, nodes)
+}
+
+// FragmentContext formats a fragment of a HTML document like Fragment but stops
+// with the context's error once the context is done.
+func FragmentContext(ctx context.Context, w io.Writer, r io.Reader) error {
+	var src bytes.Buffer
+	nodes, err := parseFragment(io.TeeReader(r, &src))
+	if err != nil {
+		return stageError(ParseStage, err)
+	}
+
+	f := newContextFormatter(ctx, DefaultOptions())
+	f.preserved = preservedSources(src.Bytes(), nodes)
+	return f.format(w, nodes)
+}
+
+func newContextFormatter(ctx context.Context, opts FormatOptions) *formatter {
+	f := newFormatter(opts)
+	f.ctx = ctx
+	return f
+}
+
+// Returns the context's error if it is done. The context is only looked at
+// once every few calls so that the check stays cheap.
+func (f *formatter) checkContext() error {
+	if f.ctx == nil {
+		return nil
+	}
+
+	f.nodesSinceCheck++
+	if f.nodesSinceCheck < contextCheckInterval {
+		return nil
+	}
+	f.nodesSinceCheck = 0
+
+	return f.ctx.Err()
+}
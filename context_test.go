@@ -0,0 +1,51 @@
+package formathtml
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Cancels the context on the first write.
+type cancelingWriter struct {
+	cancel context.CancelFunc
+	strings.Builder
+}
+
+func (c *cancelingWriter) Write(p []byte) (int, error) {
+	c.cancel()
+	return c.Builder.Write(p)
+}
+
+func TestFragmentContext(t *testing.T) {
+	w := new(strings.Builder)
+	err := FragmentContext(context.Background(), w, strings.NewReader(`<ul><li>A</li></ul>`))
+	assert.NoError(t, err)
+	assert.Equal(t, "<ul>\n  <li>A</li>\n</ul>\n", w.String())
+}
+
+func TestFragmentContextCanceledBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := new(strings.Builder)
+	err := FragmentContext(ctx, w, strings.NewReader(`<ul><li>A</li></ul>`))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, w.String())
+}
+
+func TestDocumentContextCanceledWhileFormatting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := "<!DOCTYPE html><body>" + strings.Repeat(`<div><p>Some text in a paragraph.</p></div>`, 2000)
+	full := new(strings.Builder)
+	assert.NoError(t, Document(full, strings.NewReader(input)))
+
+	w := &cancelingWriter{cancel: cancel}
+	err := DocumentContext(ctx, w, strings.NewReader(input))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, w.Len(), full.Len())
+}
@@ -0,0 +1,84 @@
+package formathtml
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func elementNode(tag string) *html.Node {
+	tag = strings.ToLower(tag)
+	return &html.Node{
+		Type:     html.ElementNode,
+		Data:     tag,
+		DataAtom: atom.Lookup([]byte(tag)),
+	}
+}
+
+// IsVoidElement reports whether the tag is a void element, like <br> or
+// <img>, that has no end tag.
+func IsVoidElement(tag string) bool {
+	return isEmptyElement(elementNode(tag), 0, 0)
+}
+
+// IsRawTextElement reports whether the contents of the tag are raw text, like
+// those of <script> and <style>, that the formatter only reindents.
+func IsRawTextElement(tag string) bool {
+	return isSpecialContentElement(elementNode(tag), 0, 0)
+}
+
+// IsInlineElement reports whether the tag is a phrasing content element, like
+// <a> or <em>, that flows inline with text.
+func IsInlineElement(tag string) bool {
+	return isInlineElement(elementNode(tag), 0, 0)
+}
+
+// DefaultInlineElements are text-level elements that read best kept on the
+// same line as the text around them. It can be used as or added to the
+// InlineElements option.
+var DefaultInlineElements = []string{
+	"abbr", "b", "cite", "code", "data", "dfn", "em", "i", "kbd", "mark", "q",
+	"s", "samp", "small", "strong", "sub", "sup", "time", "u", "var",
+}
+
+// ElementClassifier decides how the formatter lays out elements. Embed
+// DefaultElementClassifier to change only some of the decisions, for example
+// to format the custom components of a template language.
+type ElementClassifier interface {
+	// IsVoid reports whether the element has no contents and is printed
+	// without a closing tag, like <br>.
+	IsVoid(n *html.Node) bool
+
+	// IsParagraphLike reports whether the contents of the element are
+	// wrapped like the text of a paragraph, like those of <p>.
+	IsParagraphLike(n *html.Node) bool
+
+	// IsPreformatted reports whether the element and its contents are
+	// printed as is, like <pre>.
+	IsPreformatted(n *html.Node) bool
+
+	// IsSpecialContent reports whether the text of the element is only
+	// reindented, like that of <script> and <style>.
+	IsSpecialContent(n *html.Node) bool
+}
+
+// DefaultElementClassifier classifies elements the way the formatter does
+// when the ElementClassifier option is not set.
+type DefaultElementClassifier struct{}
+
+func (DefaultElementClassifier) IsVoid(n *html.Node) bool {
+	return isEmptyElement(n, 0, 0)
+}
+
+func (DefaultElementClassifier) IsParagraphLike(n *html.Node) bool {
+	return isParagraphLike(n, 0, 0)
+}
+
+func (DefaultElementClassifier) IsPreformatted(n *html.Node) bool {
+	return isPre(n, 0, 0)
+}
+
+func (DefaultElementClassifier) IsSpecialContent(n *html.Node) bool {
+	return isSpecialContentElement(n, 0, 0)
+}
@@ -0,0 +1,97 @@
+package formathtml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+func TestElementClassification(t *testing.T) {
+	tests := []struct {
+		tag     string
+		void    bool
+		rawText bool
+		inline  bool
+	}{
+		{tag: "br", void: true, inline: true},
+		{tag: "img", void: true, inline: true},
+		{tag: "META", void: true},
+		{tag: "script", rawText: true},
+		{tag: "style", rawText: true},
+		{tag: "span", inline: true},
+		{tag: "Code", inline: true},
+		{tag: "div"},
+		{tag: "p"},
+		{tag: "my-widget"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.void, IsVoidElement(test.tag), "IsVoidElement(%q)", test.tag)
+		assert.Equal(t, test.rawText, IsRawTextElement(test.tag), "IsRawTextElement(%q)", test.tag)
+		assert.Equal(t, test.inline, IsInlineElement(test.tag), "IsInlineElement(%q)", test.tag)
+	}
+}
+
+type iconClassifier struct {
+	DefaultElementClassifier
+}
+
+func (c iconClassifier) IsVoid(n *html.Node) bool {
+	return n.Data == "my-icon" || c.DefaultElementClassifier.IsVoid(n)
+}
+
+func TestFragmentWithElementClassifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:  "elements classified as void are printed without closing tags",
+			input: `<div><my-icon name="star"></my-icon><span>Starred</span><br></div>`,
+			expected: `<div>
+  <my-icon name="star">
+  <span>Starred</span>
+  <br>
+</div>
+`,
+		},
+		{
+			name:  "content the parser put inside custom void elements is printed after them",
+			input: `<div><my-icon name="star"><span>Starred</span></div>`,
+			expected: `<div>
+  <my-icon name="star">
+  <span>Starred</span>
+</div>
+`,
+		},
+		{
+			name:     "custom void elements in paragraphs are printed without closing tags",
+			input:    `<p>Rate it <my-icon name="star"></my-icon> now</p>`,
+			expected: "<p>Rate it <my-icon name=\"star\"> now</p>\n",
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.ElementClassifier = iconClassifier{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, FragmentWithOptions(&b, strings.NewReader(test.input), opts))
+			assert.Equal(t, test.expected, b.String())
+			assertIdempotent(t, b.String(), opts)
+		})
+	}
+}
+
+func TestDefaultElementClassifier(t *testing.T) {
+	c := DefaultElementClassifier{}
+	assert.True(t, c.IsVoid(elementNode("img")))
+	assert.True(t, c.IsParagraphLike(elementNode("figcaption")))
+	assert.True(t, c.IsPreformatted(elementNode("pre")))
+	assert.True(t, c.IsSpecialContent(elementNode("script")))
+	assert.False(t, c.IsVoid(elementNode("my-icon")))
+}
@@ -0,0 +1,49 @@
+package formathtml
+
+// Stage is the step of formatting that an error happened in.
+type Stage int
+
+const (
+	// ParseStage is reading and parsing the input.
+	ParseStage Stage = iota + 1
+
+	// RenderStage is printing the formatted output, including writing it to
+	// the destination.
+	RenderStage
+)
+
+func (s Stage) String() string {
+	switch s {
+	case ParseStage:
+		return "parse"
+	case RenderStage:
+		return "render"
+	}
+
+	return "unknown stage"
+}
+
+// FormatError is returned by the formatting functions when parsing the input
+// or writing the output fails. Use errors.As to find out which stage failed
+// and errors.Is or Unwrap to get at the underlying error.
+type FormatError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *FormatError) Error() string {
+	return "formathtml: " + e.Stage.String() + ": " + e.Err.Error()
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// Wraps a non-nil error in a FormatError for the stage.
+func stageError(stage Stage, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &FormatError{Stage: stage, Err: err}
+}
@@ -0,0 +1,113 @@
+package formathtml
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestFormatErrorStages(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   func() error
+		stage    Stage
+		expected string
+	}{
+		{
+			name:     "reading a fragment fails",
+			format:   func() error { return Fragment(io.Discard, failingReader{}) },
+			stage:    ParseStage,
+			expected: "formathtml: parse: connection reset",
+		},
+		{
+			name:     "reading a document fails",
+			format:   func() error { return Document(io.Discard, failingReader{}) },
+			stage:    ParseStage,
+			expected: "formathtml: parse: connection reset",
+		},
+		{
+			name:     "writing a fragment fails",
+			format:   func() error { return Fragment(failingWriter{}, strings.NewReader("<p>x</p>")) },
+			stage:    RenderStage,
+			expected: "formathtml: render: disk full",
+		},
+		{
+			name:     "writing a document fails",
+			format:   func() error { return Document(failingWriter{}, strings.NewReader("<p>x</p>")) },
+			stage:    RenderStage,
+			expected: "formathtml: render: disk full",
+		},
+		{
+			name:     "reading a fragment to minify fails",
+			format:   func() error { return Minify(io.Discard, failingReader{}) },
+			stage:    ParseStage,
+			expected: "formathtml: parse: connection reset",
+		},
+		{
+			name:     "writing a minified fragment fails",
+			format:   func() error { return Minify(failingWriter{}, strings.NewReader("<p>x</p>")) },
+			stage:    RenderStage,
+			expected: "formathtml: render: disk full",
+		},
+		{
+			name:     "reading a streamed fragment fails",
+			format:   func() error { return StreamFragment(io.Discard, failingReader{}) },
+			stage:    ParseStage,
+			expected: "formathtml: parse: connection reset",
+		},
+		{
+			name:     "writing a streamed fragment fails",
+			format:   func() error { return StreamFragment(failingWriter{}, strings.NewReader("<p>x</p>")) },
+			stage:    RenderStage,
+			expected: "formathtml: render: disk full",
+		},
+		{
+			name: "reading a fragment for its levels fails",
+			format: func() error {
+				_, err := FragmentLevels(io.Discard, failingReader{}, DefaultOptions())
+				return err
+			},
+			stage:    ParseStage,
+			expected: "formathtml: parse: connection reset",
+		},
+		{
+			name: "reading a document for its levels fails",
+			format: func() error {
+				_, err := DocumentLevels(io.Discard, failingReader{}, DefaultOptions())
+				return err
+			},
+			stage:    ParseStage,
+			expected: "formathtml: parse: connection reset",
+		},
+		{
+			name: "writing a document with its levels fails",
+			format: func() error {
+				_, err := DocumentLevels(failingWriter{}, strings.NewReader("<p>x</p>"), DefaultOptions())
+				return err
+			},
+			stage:    RenderStage,
+			expected: "formathtml: render: disk full",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.format()
+			var formatErr *FormatError
+			require.True(t, errors.As(err, &formatErr))
+			assert.Equal(t, test.stage, formatErr.Stage)
+			assert.EqualError(t, err, test.expected)
+			assert.NotNil(t, errors.Unwrap(err))
+		})
+	}
+}
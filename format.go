@@ -3,8 +3,12 @@ package formathtml
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -16,326 +20,2126 @@ import (
 const indentString = "  "
 const paragraphLength = 100
 
+// Used as the wrapping limit when wrapping is disabled.
+const noWidthLimit = ^uint(0) >> 1
+
 type NodePrinter func(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error)
 type Conditional func(n *html.Node, level int, col uint) bool
 type ConditionalAndContext[T comparable] func(n *html.Node, value T) bool
 
-func conditionWithContext[T comparable](value T, cond ConditionalAndContext[T]) Conditional {
-	return func(n *html.Node, level int, col uint) bool {
-		return cond(n, value)
+// FormatOptions changes how the formatter lays out its output.
+type FormatOptions struct {
+	// Indent is the string printed once for every level of indentation. An
+	// empty string prints everything without indentation.
+	Indent string
+
+	// IndentSize, when not zero, replaces Indent with that many spaces. A
+	// negative size uses two spaces.
+	IndentSize int
+
+	// UseTabs replaces Indent with a tab. It takes precedence over
+	// IndentSize.
+	UseTabs bool
+
+	// MaxWidth is the column after which paragraphs and long attribute values
+	// are wrapped. Zero disables wrapping.
+	MaxWidth uint
+
+	// LineEnding is printed at the end of every line, for example "\r\n".
+	// An empty string prints "\n". Line breaks inside pre elements are kept
+	// as written.
+	LineEnding string
+
+	// FinalNewline ends the output with exactly one line ending, however many
+	// the input ended with. When false the output ends without one so that
+	// it can be embedded inline. Document and Fragment turn it on, but a zero
+	// FormatOptions leaves it off, so options that do not start from
+	// DefaultOptions must set it to keep the final line ending.
+	FinalNewline bool
+
+	// CollapseBooleanAttributes prints boolean attributes whose value is empty
+	// or the attribute name, like disabled="disabled", without a value.
+	CollapseBooleanAttributes bool
+
+	// BooleanAttributes lists the attributes that can be collapsed. The
+	// boolean attributes of the HTML spec are used when it is nil.
+	BooleanAttributes []string
+
+	// NonBooleanAttributes lists attributes that are never collapsed even if
+	// they are in BooleanAttributes. Enumerated attributes that only look
+	// boolean, like aria-* and contenteditable, are never collapsed either.
+	NonBooleanAttributes []string
+
+	// PreserveMultilineAttributes prints attribute values that span several
+	// lines, like pretty printed JSON, with their lines kept. The lines are
+	// reindented to line up with the element while keeping their relative
+	// indentation.
+	PreserveMultilineAttributes bool
+
+	// RuneWidth returns the number of columns a rune takes up when measuring
+	// text for wrapping. Each rune is one column wide when it is nil.
+	RuneWidth func(r rune) uint
+
+	// EastAsianWidth measures wide and fullwidth runes, like CJK ideographs,
+	// as two columns so that text in those scripts wraps at its visual width.
+	// It is ignored when RuneWidth is set.
+	EastAsianWidth bool
+
+	// GraphemeWidth measures each grapheme cluster, like an accented letter
+	// written with a combining mark or an emoji sequence joined by zero width
+	// joiners, as the width of its first rune so that such text does not wrap
+	// too early. It can be combined with EastAsianWidth or RuneWidth.
+	GraphemeWidth bool
+
+	// SentenceSpacing is the number of spaces printed after sentence-ending
+	// punctuation in wrapped text. Zero keeps the spacing of the input. The
+	// periods of common abbreviations, like "Mr." and "e.g.", do not end a
+	// sentence.
+	SentenceSpacing int
+
+	// TokenizedAttributes lists attributes whose values are space-separated
+	// token lists, like rel or sandbox. Their tokens are separated by single
+	// spaces and wrapped onto new lines when they go past MaxWidth.
+	TokenizedAttributes []string
+
+	// SeparateTopLevelElements prints a blank line between the top level
+	// block elements of a fragment, where comments may come between them,
+	// unless MaxBlankLines is zero. Blank lines kept from the source by
+	// PreserveBlankLines count towards it.
+	SeparateTopLevelElements bool
+
+	// PreserveInteractiveWhitespace keeps a single space where the text of a
+	// button, link, label or summary starts or ends with whitespace instead of
+	// trimming it.
+	PreserveInteractiveWhitespace bool
+
+	// InlineVoidElements keeps void elements like <img> that sit between text
+	// in block elements on the same line as the text around them.
+	InlineVoidElements bool
+
+	// InlineElements lists elements that are kept on the same line as the
+	// text around them, wrapped like the contents of a paragraph, instead of
+	// being printed on their own lines. DefaultInlineElements is a good
+	// starting point.
+	InlineElements []string
+
+	// DoctypeCase sets the case of the doctype keyword, its name and the
+	// PUBLIC and SYSTEM keywords. The quoted identifiers keep their case.
+	DoctypeCase DoctypeCase
+
+	// CanonicalLegacyDoctype collapses runs of whitespace in the identifiers
+	// of legacy doctypes, like the HTML 4.01 ones, to single spaces.
+	CanonicalLegacyDoctype bool
+
+	// PreserveUnknownElements prints the subtree of elements that are not part
+	// of the known HTML element set (e.g. custom elements) as is.
+	PreserveUnknownElements bool
+
+	// AccessibilityHints prints accessibility related attributes (alt, aria-*
+	// and role) before the others and warns about images without alt text.
+	AccessibilityHints bool
+
+	// CheckTemplateDirectives warns about template control actions like
+	// `{{ if }}` or `{{ range }}` that have no matching `{{ end }}`.
+	CheckTemplateDirectives bool
+
+	// BufferSize is the size of the buffer the output is collected in before
+	// it is written to the destination writer. Zero uses the bufio default
+	// size and a negative size writes directly to the destination.
+	BufferSize int
+
+	// CollapseWhitespace replaces each run of whitespace in text with a single
+	// space, except in preformatted elements and scripts and styles.
+	// Non-breaking spaces are kept.
+	CollapseWhitespace bool
+
+	// StripIgnoreAttribute leaves out the data-formathtml="ignore" attribute
+	// of elements that are printed as is. Formatting the output again formats
+	// those elements.
+	StripIgnoreAttribute bool
+
+	// CompactShortElements prints elements that hold only text and inline
+	// elements, like a list item with a link, on one line when they fit
+	// within MaxWidth instead of putting each child on its own line.
+	CompactShortElements bool
+
+	// AlwaysExpandParagraphs puts the contents of paragraph-like elements on
+	// indented lines between their tags even when they would fit on one line
+	// with them. Empty paragraphs are still printed on one line.
+	AlwaysExpandParagraphs bool
+
+	// TrimTrailingWhitespace removes the spaces and tabs at the end of every
+	// line of the output, like those left in the source of scripts and
+	// styles. Lines inside elements printed as is, like pre and textarea, and
+	// regions where formatting is turned off are kept as they are.
+	TrimTrailingWhitespace bool
+
+	// ElementClassifier decides which elements are void, paragraph-like,
+	// preformatted or special content. DefaultElementClassifier is used when
+	// it is nil. The parser does not know about elements a classifier makes
+	// void, so it puts what follows them inside them; that content is printed
+	// after them as if it were their siblings.
+	ElementClassifier ElementClassifier
+
+	// LowercaseTags prints the names of known HTML elements in lowercase.
+	// Elements in SVG and MathML, like linearGradient, keep their case.
+	LowercaseTags bool
+
+	// LowercaseAttributes prints attribute names in lowercase. The case
+	// sensitive attributes of SVG and MathML, like viewBox, keep their case.
+	LowercaseAttributes bool
+
+	// IndentHtmlChildren indents the head and body elements one level under
+	// the html element instead of printing them at its level.
+	IndentHtmlChildren bool
+
+	// DeduplicateAttributes prints only the first of the attributes with the
+	// same name, which is the one browsers use.
+	DeduplicateAttributes bool
+
+	// CollapseInterElementWhitespace leaves out text between elements that
+	// is only whitespace, even when it has non-breaking spaces. Text that is
+	// the only content of an element is kept.
+	CollapseInterElementWhitespace bool
+
+	// PreserveBlankLines keeps blank lines between block elements that are
+	// separated by one or more blank lines in the source, up to
+	// MaxBlankLines of them. MaxBlankLines must be set too, since its zero
+	// value keeps none.
+	PreserveBlankLines bool
+
+	// MaxBlankLines caps how many blank lines are kept between block elements
+	// when preserving blank lines. Zero keeps none. Document and Fragment keep
+	// one.
+	MaxBlankLines int
+
+	// SelfCloseVoidElements prints void elements like <br> with a closing
+	// slash, as in <br />.
+	SelfCloseVoidElements bool
+
+	// XHTML prints markup that is also well-formed XML: void elements are
+	// self-closed, tag and attribute names are lowercased and boolean
+	// attributes are given their name as value, as in
+	// disabled="disabled". It overrides SelfCloseVoidElements, LowercaseTags,
+	// LowercaseAttributes and CollapseBooleanAttributes. An xmlns attribute is
+	// not added; the one on the html element, if any, is kept.
+	XHTML bool
+
+	// AttributeQuote is the quote attribute values are printed between,
+	// either '"' or '\''. Zero uses '"'. Quotes of the same kind in a value
+	// are escaped.
+	AttributeQuote byte
+
+	// SortAttributes prints the attributes of each element sorted by name.
+	// Attributes with the same name keep their order.
+	SortAttributes bool
+
+	// StripComments leaves comments out of the output. Conditional comments
+	// like <!--[if IE]> are kept since they change how old browsers render
+	// the page.
+	StripComments bool
+
+	// Normalize smooths over differences in how versions of the HTML parser
+	// build the tree so that upgrading it does not change formatted output.
+	// Attributes are printed sorted by name and attributes the parser moved
+	// into a namespace, like xlink:href, are printed with their prefix.
+	Normalize bool
+
+	// WrapPlainText wraps input that has no tags at all at MaxWidth as if it
+	// were a single paragraph.
+	WrapPlainText bool
+
+	// WrapSVGAttributes wraps long transform and style attributes of SVG
+	// elements between their transform functions and style declarations.
+	WrapSVGAttributes bool
+
+	// WrapAttributes prints each attribute of an opening tag that would go
+	// past MaxWidth on its own line, indented under the element, with the
+	// closing bracket on a line of its own. Tags in wrapped paragraphs are
+	// wrapped with the text instead.
+	WrapAttributes bool
+
+	// MaxAttributesPerLine, when positive, wraps opening tags with more
+	// attributes than it and puts at most that many attributes on each line
+	// of wrapped tags instead of one. Tags in wrapped paragraphs are wrapped
+	// with the text instead.
+	MaxAttributesPerLine int
+
+	// OnWrap is called when the contents of an element are wrapped onto a new
+	// line because they did not fit within MaxWidth. It receives the element
+	// and the line that would have gone past the limit, without the content
+	// that was moved to the next line.
+	OnWrap func(n *html.Node, line string)
+
+	// Warn is called for problems found in the input that do not stop the
+	// formatting. Warnings are discarded when it is nil.
+	Warn func(n *html.Node, message string)
+}
+
+type formatter struct {
+	opts                 FormatOptions
+	tokenizedAttributes  map[string]bool
+	booleanAttributes    map[string]bool
+	nonBooleanAttributes map[string]bool
+	inlineElements       map[string]bool
+	levels               *levelRecorder
+	classifier           ElementClassifier
+
+	// trimmer, when trimming trailing whitespace, is the writer that trims it
+	trimmer *trailingSpaceWriter
+
+	// startLevel is the indentation level of the nodes given to format
+	startLevel int
+
+	// compacting is the element being printed on one line like a paragraph
+	compacting *html.Node
+
+	// preserved maps the formatting off comments to the source of their
+	// regions
+	preserved map[*html.Node]string
+
+	// ctx, when set, cancels formatting once it is done
+	ctx             context.Context
+	nodesSinceCheck int
+}
+
+func newFormatter(opts FormatOptions) *formatter {
+	opts.Indent = indentUnit(opts)
+	if opts.XHTML {
+		opts.SelfCloseVoidElements = true
+		opts.LowercaseTags = true
+		opts.LowercaseAttributes = true
+		opts.CollapseBooleanAttributes = false
+	}
+	if opts.EastAsianWidth && opts.RuneWidth == nil {
+		opts.RuneWidth = EastAsianRuneWidth
+	}
+	f := &formatter{
+		opts:                 opts,
+		tokenizedAttributes:  keySet(opts.TokenizedAttributes),
+		booleanAttributes:    keySet(opts.BooleanAttributes),
+		nonBooleanAttributes: keySet(opts.NonBooleanAttributes),
+		inlineElements:       keySet(opts.InlineElements),
+		classifier:           opts.ElementClassifier,
+	}
+	if opts.BooleanAttributes == nil {
+		f.booleanAttributes = keySet(defaultBooleanAttributes)
+	}
+	if f.classifier == nil {
+		f.classifier = DefaultElementClassifier{}
+	}
+
+	return f
+}
+
+// Returns the string printed for each level of indentation.
+func indentUnit(opts FormatOptions) string {
+	switch {
+	case opts.UseTabs:
+		return "\t"
+	case opts.IndentSize < 0:
+		return indentString
+	case opts.IndentSize > 0:
+		return strings.Repeat(" ", opts.IndentSize)
+	}
+
+	return opts.Indent
+}
+
+// Returns a set of the lower cased keys.
+func keySet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[strings.ToLower(key)] = true
+	}
+
+	return set
+}
+
+// The boolean attributes defined by the HTML spec.
+var defaultBooleanAttributes = []string{
+	"allowfullscreen", "async", "autofocus", "autoplay", "checked", "controls",
+	"default", "defer", "disabled", "formnovalidate", "hidden", "inert",
+	"ismap", "itemscope", "loop", "multiple", "muted", "nomodule",
+	"novalidate", "open", "playsinline", "readonly", "required", "reversed",
+	"selected",
+}
+
+// Enumerated attributes that accept "true" and "false" and so must keep their
+// values.
+var enumeratedAttributes = map[string]bool{
+	"autocapitalize":  true,
+	"contenteditable": true,
+	"draggable":       true,
+	"spellcheck":      true,
+	"translate":       true,
+}
+
+// DefaultOptions returns the options used by Document, Fragment and Nodes:
+// two space indentation, wrapping at 100 columns, "\n" line endings and
+// comments kept. Change them as needed and pass them to one of the
+// WithOptions functions instead of starting from a zero FormatOptions, which
+// turns wrapping off, drops the final line ending and keeps no blank lines
+// even with PreserveBlankLines.
+func DefaultOptions() FormatOptions {
+	return FormatOptions{
+		Indent:        indentString,
+		MaxWidth:      paragraphLength,
+		FinalNewline:  true,
+		MaxBlankLines: 1,
+	}
+}
+
+// Reports that the contents of the element were wrapped because they did not
+// fit on the given line.
+func (f *formatter) reportWrap(n *html.Node, line string) {
+	if f.opts.OnWrap != nil {
+		f.opts.OnWrap(n, line)
+	}
+}
+
+// Returns a wrap reporter for word wrappers that formats the contents of n.
+func (f *formatter) wrapReporter(n *html.Node) func(line string) {
+	if f.opts.OnWrap == nil {
+		return nil
+	}
+
+	return func(line string) {
+		f.reportWrap(n, line)
+	}
+}
+
+// Returns the number of columns s takes up.
+func (f *formatter) stringWidth(s string) uint {
+	return textWidth(s, f.opts.RuneWidth, f.opts.GraphemeWidth)
+}
+
+// Returns the column limit for wrapping.
+func (f *formatter) maxWidth() uint {
+	if f.opts.MaxWidth == 0 {
+		return noWidthLimit
+	}
+
+	return f.opts.MaxWidth
+}
+
+func (f *formatter) lineEnding() string {
+	if f.opts.LineEnding == "" {
+		return "\n"
+	}
+
+	return f.opts.LineEnding
+}
+
+func (f *formatter) warn(n *html.Node, format string, a ...any) {
+	if f.opts.Warn != nil {
+		f.opts.Warn(n, fmt.Sprintf(format, a...))
+	}
+}
+
+// Reports problems found on an element before it is printed.
+func (f *formatter) checkElement(n *html.Node) {
+	if f.opts.AccessibilityHints && n.DataAtom == atom.Img && !hasAttribute(n, "alt") {
+		f.warn(n, "<img> is missing an alt attribute")
+	}
+}
+
+// Returns the attributes of the element in the order they should be printed.
+func (f *formatter) attributes(n *html.Node) []html.Attribute {
+	attrs := n.Attr
+	if f.opts.Normalize {
+		attrs = normalizedAttributes(attrs)
+	}
+	if f.opts.LowercaseAttributes {
+		attrs = lowercaseAttributes(attrs)
+	}
+	if f.opts.DeduplicateAttributes {
+		attrs = dedupedAttributes(attrs)
+	}
+	if f.opts.StripIgnoreAttribute && isIgnoredElement(n, 0, 0) {
+		attrs = withoutIgnoreAttribute(attrs)
+	}
+	if f.opts.XHTML {
+		attrs = f.expandedBooleanAttributes(attrs)
+	}
+	if f.opts.SortAttributes || f.opts.Normalize {
+		attrs = append([]html.Attribute(nil), attrs...)
+		sort.SliceStable(attrs, func(i, j int) bool {
+			return attrs[i].Key < attrs[j].Key
+		})
+	}
+	if f.opts.AccessibilityHints {
+		attrs = append([]html.Attribute(nil), attrs...)
+		sort.SliceStable(attrs, func(i, j int) bool {
+			return accessibilityRank(attrs[i].Key) < accessibilityRank(attrs[j].Key)
+		})
+	}
+
+	return attrs
+}
+
+// Attributes of SVG and MathML whose names are case sensitive.
+var caseSensitiveAttributes = map[string]bool{
+	"attributeName": true, "attributeType": true, "baseFrequency": true,
+	"baseProfile": true, "calcMode": true, "clipPathUnits": true,
+	"definitionURL": true, "diffuseConstant": true, "edgeMode": true,
+	"filterUnits": true, "glyphRef": true, "gradientTransform": true,
+	"gradientUnits": true, "kernelMatrix": true, "kernelUnitLength": true,
+	"keyPoints": true, "keySplines": true, "keyTimes": true,
+	"lengthAdjust": true, "limitingConeAngle": true, "markerHeight": true,
+	"markerUnits": true, "markerWidth": true, "maskContentUnits": true,
+	"maskUnits": true, "numOctaves": true, "pathLength": true,
+	"patternContentUnits": true, "patternTransform": true,
+	"patternUnits": true, "pointsAtX": true, "pointsAtY": true,
+	"pointsAtZ": true, "preserveAlpha": true, "preserveAspectRatio": true,
+	"primitiveUnits": true, "refX": true, "refY": true, "repeatCount": true,
+	"repeatDur": true, "requiredExtensions": true, "requiredFeatures": true,
+	"specularConstant": true, "specularExponent": true, "spreadMethod": true,
+	"startOffset": true, "stdDeviation": true, "stitchTiles": true,
+	"surfaceScale": true, "systemLanguage": true, "tableValues": true,
+	"targetX": true, "targetY": true, "textLength": true, "viewBox": true,
+	"viewTarget": true, "xChannelSelector": true, "yChannelSelector": true,
+	"zoomAndPan": true,
+}
+
+// Returns a copy of the attributes with their names in lowercase, except for
+// the case sensitive ones.
+func lowercaseAttributes(attrs []html.Attribute) []html.Attribute {
+	lowered := make([]html.Attribute, len(attrs))
+	for i, a := range attrs {
+		if !caseSensitiveAttributes[a.Key] {
+			a.Key = strings.ToLower(a.Key)
+		}
+		lowered[i] = a
+	}
+
+	return lowered
+}
+
+// Returns the attributes without the ones whose name was already used by an
+// earlier attribute.
+func dedupedAttributes(attrs []html.Attribute) []html.Attribute {
+	seen := make(map[string]bool, len(attrs))
+	deduped := make([]html.Attribute, 0, len(attrs))
+	for _, a := range attrs {
+		key := a.Namespace + ":" + strings.ToLower(a.Key)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, a)
+	}
+
+	return deduped
+}
+
+// Returns a copy of the attributes with boolean attributes without a value
+// given their name as value, as XML requires every attribute to have one.
+func (f *formatter) expandedBooleanAttributes(attrs []html.Attribute) []html.Attribute {
+	expanded := make([]html.Attribute, len(attrs))
+	for i, a := range attrs {
+		if a.Val == "" && f.isBooleanAttribute(a) {
+			a.Val = a.Key
+		}
+		expanded[i] = a
+	}
+
+	return expanded
+}
+
+// Returns the attributes without the one that marks elements printed as is.
+func withoutIgnoreAttribute(attrs []html.Attribute) []html.Attribute {
+	kept := make([]html.Attribute, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Namespace != "" || a.Key != ignoreAttribute {
+			kept = append(kept, a)
+		}
+	}
+
+	return kept
+}
+
+// Returns a copy of the attributes with namespace prefixes restored.
+func normalizedAttributes(attrs []html.Attribute) []html.Attribute {
+	normalized := make([]html.Attribute, len(attrs))
+	for i, a := range attrs {
+		if a.Namespace != "" {
+			a.Key = a.Namespace + ":" + a.Key
+			a.Namespace = ""
+		}
+		normalized[i] = a
+	}
+
+	return normalized
+}
+
+// Lower ranked attributes are printed first when accessibility hints are on.
+func accessibilityRank(key string) int {
+	switch {
+	case key == "alt":
+		return 0
+	case strings.HasPrefix(key, "aria-"):
+		return 1
+	case key == "role":
+		return 2
 	}
+
+	return 3
 }
 
 // Document formats a HTML document.
 func Document(w io.Writer, r io.Reader) (err error) {
-	node, err := html.ParseWithOptions(r, html.ParseOptionEnableScripting(false))
+	return DocumentWithOptions(w, r, DefaultOptions())
+}
+
+// DocumentWithOptions formats a HTML document using the given options.
+func DocumentWithOptions(w io.Writer, r io.Reader, opts FormatOptions) (err error) {
+	// with scripting disabled the contents of noscript, even in the head, are
+	// parsed as elements instead of raw text
+	var src bytes.Buffer
+	node, err := html.ParseWithOptions(io.TeeReader(r, &src), html.ParseOptionEnableScripting(false))
 	if err != nil {
-		return err
+		return stageError(ParseStage, err)
 	}
-	return Nodes(w, []*html.Node{node})
+
+	nodes := []*html.Node{node}
+	f := newFormatter(opts)
+	f.preserved = preservedSources(src.Bytes(), nodes)
+	return f.format(w, nodes)
 }
 
-// Fragment formats a fragment of a HTML document.
+// Fragment formats a fragment of a HTML document. Formatting is idempotent:
+// formatting the output of Fragment again gives the same output.
 func Fragment(w io.Writer, r io.Reader) (err error) {
-	context := &html.Node{
-		Type: html.ElementNode,
+	return FragmentWithOptions(w, r, DefaultOptions())
+}
+
+// FragmentWithOptions formats a fragment of a HTML document using the given
+// options.
+func FragmentWithOptions(w io.Writer, r io.Reader, opts FormatOptions) (err error) {
+	var src bytes.Buffer
+	nodes, err := parseFragment(io.TeeReader(r, &src))
+	if err != nil {
+		return stageError(ParseStage, err)
 	}
-	nodes, err := html.ParseFragmentWithOptions(r, context, html.ParseOptionEnableScripting(false))
+
+	f := newFormatter(opts)
+	f.preserved = preservedSources(src.Bytes(), nodes)
+	return f.format(w, nodes)
+}
+
+// FormatBody parses a HTML document and formats only the children of its body
+// as a fragment. The whole document is formatted when it has no body, like a
+// frameset document.
+func FormatBody(w io.Writer, r io.Reader, opts FormatOptions) error {
+	var src bytes.Buffer
+	doc, err := html.ParseWithOptions(io.TeeReader(r, &src), html.ParseOptionEnableScripting(false))
 	if err != nil {
-		return err
+		return stageError(ParseStage, err)
+	}
+
+	nodes := []*html.Node{doc}
+	if body := findElement(doc, atom.Body); body != nil {
+		nodes = childNodes(body)
 	}
-	return Nodes(w, nodes)
+
+	f := newFormatter(opts)
+	f.preserved = preservedSources(src.Bytes(), nodes)
+	return f.format(w, nodes)
 }
 
-// Nodes formats a slice of HTML nodes.
-func Nodes(w io.Writer, nodes []*html.Node) (err error) {
-	colAfter := uint(0)
-	for _, node := range nodes {
-		if colAfter, err = printNode(w, node, 0, colAfter); err != nil {
-			return
+// Returns the first element of the kind in the tree in document order.
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, a); found != nil {
+			return found
 		}
 	}
-	return
+
+	return nil
 }
 
-// Is this node a tag with no end tag such as <meta> or <br>?
-// http://www.w3.org/TR/html-markup/syntax.html#syntax-elements
-func isEmptyElement(n *html.Node, _ int, _ uint) bool {
-	switch n.DataAtom {
-	case atom.Area, atom.Base, atom.Br, atom.Col, atom.Command, atom.Embed,
-		atom.Hr, atom.Img, atom.Input, atom.Keygen, atom.Link,
-		atom.Meta, atom.Param, atom.Source, atom.Track, atom.Wbr:
-		return true
+func parseFragment(r io.Reader) ([]*html.Node, error) {
+	context := &html.Node{
+		Type: html.ElementNode,
 	}
+	return html.ParseFragmentWithOptions(r, context, html.ParseOptionEnableScripting(false))
+}
 
-	return false
+// DocumentN formats a HTML document like Document and returns the number of
+// bytes written to w.
+func DocumentN(w io.Writer, r io.Reader) (int64, error) {
+	c := &byteCounter{w: w}
+	err := Document(c, r)
+	return c.n, err
 }
 
-func isBreakElement(n *html.Node, _ int, _ uint) bool {
-	return n.DataAtom == atom.Br
+// FragmentN formats a fragment of a HTML document like Fragment and returns
+// the number of bytes written to w.
+func FragmentN(w io.Writer, r io.Reader) (int64, error) {
+	c := &byteCounter{w: w}
+	err := Fragment(c, r)
+	return c.n, err
 }
 
-func isNonEmptyElement(n *html.Node, level int, col uint) bool {
-	return !isEmptyElement(n, level, col)
+// Holds back the line endings at the end of what was written so far so that
+// the output can be ended with exactly as many as wanted.
+type trailingNewlineWriter struct {
+	w       io.Writer
+	held    []byte
+	written bool
 }
 
-func isSpecialContentElement(n *html.Node, _ int, _ uint) bool {
-	if n != nil {
-		switch n.DataAtom {
-		case atom.Style,
-			atom.Script:
-			return true
+func (t *trailingNewlineWriter) Write(p []byte) (int, error) {
+	content := bytes.TrimRight(p, "\r\n")
+	if len(content) > 0 {
+		if len(t.held) > 0 {
+			if _, err := t.w.Write(t.held); err != nil {
+				return 0, err
+			}
+			t.held = t.held[:0]
 		}
+		if _, err := t.w.Write(content); err != nil {
+			return 0, err
+		}
+		t.written = true
 	}
-	return false
+	t.held = append(t.held, p[len(content):]...)
+
+	return len(p), nil
 }
 
-func isChildOfSpecialContentElement(n *html.Node, level int, col uint) bool {
-	return isSpecialContentElement(n.Parent, level, col)
+// Drops the held line endings and writes lineEnding instead if anything was
+// written.
+func (t *trailingNewlineWriter) end(lineEnding string) error {
+	if !t.written || lineEnding == "" {
+		return nil
+	}
+
+	_, err := io.WriteString(t.w, lineEnding)
+	return err
 }
 
-func isScriptWithSrcAttribute(n *html.Node, _ int, _ uint) bool {
-	return n.DataAtom == atom.Script && hasSrcAttribute(n)
+// Drops the spaces and tabs at the end of each line written through it, except
+// while keep is set.
+type trailingSpaceWriter struct {
+	w    io.Writer
+	held []byte
+	keep bool
 }
 
-func hasSrcAttribute(n *html.Node) bool {
-	for _, a := range n.Attr {
-		if a.Key == "src" {
-			return true
+func (t *trailingSpaceWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		line, rest, found := bytes.Cut(p, newlineBytes)
+		p = rest
+
+		lineEnding := newlineBytes
+		if content, ok := bytes.CutSuffix(line, []byte("\r")); ok && found {
+			line, lineEnding = content, []byte("\r\n")
+		}
+
+		content := line
+		if !t.keep {
+			content = bytes.TrimRight(line, " \t")
+		}
+		if len(content) > 0 {
+			if _, err := t.w.Write(append(t.held, content...)); err != nil {
+				return 0, err
+			}
+			t.held = t.held[:0]
+		}
+
+		if found {
+			t.held = t.held[:0]
+			if _, err := t.w.Write(lineEnding); err != nil {
+				return 0, err
+			}
+		} else {
+			t.held = append(t.held, line[len(content):]...)
 		}
 	}
-	return false
+
+	return n, nil
 }
 
-func isParagraphLike(n *html.Node, _ int, _ uint) bool {
-	switch n.DataAtom {
-	case atom.P, atom.Caption, atom.Figcaption:
-		return true
+// Runs the printer without trimming the whitespace at the end of the lines it
+// prints.
+func (f *formatter) keepTrailingWhitespace(printer NodePrinter) NodePrinter {
+	return func(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+		f.keepingTrailingWhitespace(func() {
+			colAfter, err = printer(w, n, level, col)
+		})
+		return
 	}
+}
 
-	return false
+// Calls print without trimming the whitespace at the end of the lines written
+// meanwhile.
+func (f *formatter) keepingTrailingWhitespace(print func()) {
+	if f.trimmer == nil || f.trimmer.keep {
+		print()
+		return
+	}
+
+	f.trimmer.keep = true
+	defer func() { f.trimmer.keep = false }()
+	print()
 }
 
-func isPre(n *html.Node, _ int, _ uint) bool {
-	return n.DataAtom == atom.Pre
+// Returns the line ending the output ends with, if any.
+func (f *formatter) finalLineEnding() string {
+	if !f.opts.FinalNewline {
+		return ""
+	}
+
+	return f.lineEnding()
 }
 
-func isEmptyTextNode(n *html.Node, _ int, _ uint) bool {
-	return n.Type == html.TextNode && strings.TrimSpace(n.Data) == ""
+// Counts the bytes written through it.
+type byteCounter struct {
+	w io.Writer
+	n int64
 }
 
-func getFirstRune(s string) rune {
-	r, _ := utf8.DecodeRuneInString(s)
-	return r
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
-func hasSingleTextChild(n *html.Node, _ int, _ uint) bool {
-	return n != nil && n.FirstChild != nil && n.FirstChild == n.LastChild && n.FirstChild.Type == html.TextNode
+// FormatString formats a fragment of a HTML document held in a string.
+func FormatString(s string) (string, error) {
+	var b strings.Builder
+	if err := Fragment(&b, strings.NewReader(s)); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
 }
 
-func isSingleTextChild(n *html.Node, level int, col uint) bool {
-	return hasSingleTextChild(n.Parent, level, col)
+// FormatDocumentString formats a HTML document held in a string.
+func FormatDocumentString(s string) (string, error) {
+	var b strings.Builder
+	if err := Document(&b, strings.NewReader(s)); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
 }
 
-func isHtmlElement(n *html.Node, _ int, _ uint) bool {
-	return n.DataAtom == atom.Html
+// Nodes formats a slice of HTML nodes.
+func Nodes(w io.Writer, nodes []*html.Node) (err error) {
+	return NodesWithOptions(w, nodes, DefaultOptions())
+}
+
+// NodesWithOptions formats a slice of HTML nodes using the given options.
+func NodesWithOptions(w io.Writer, nodes []*html.Node, opts FormatOptions) (err error) {
+	return newFormatter(opts).format(w, nodes)
+}
+
+// Node formats a single HTML node and its subtree.
+func Node(w io.Writer, n *html.Node) (err error) {
+	return Nodes(w, []*html.Node{n})
+}
+
+// ErrNegativeLevel is returned by NodesAtLevel for a level below zero.
+var ErrNegativeLevel = errors.New("formathtml: negative indentation level")
+
+// NodesAtLevel formats a slice of HTML nodes indented as if they were nested
+// level elements deep, for output that is put inside an already indented
+// document.
+func NodesAtLevel(w io.Writer, nodes []*html.Node, level int) (err error) {
+	if level < 0 {
+		return ErrNegativeLevel
+	}
+
+	f := newFormatter(DefaultOptions())
+	f.startLevel = level
+	return f.format(w, nodes)
+}
+
+// Prints the nodes, returning errors as render FormatErrors.
+func (f *formatter) format(w io.Writer, nodes []*html.Node) error {
+	return stageError(RenderStage, f.render(w, nodes))
+}
+
+func (f *formatter) render(w io.Writer, nodes []*html.Node) (err error) {
+	if f.ctx != nil {
+		if err = f.ctx.Err(); err != nil {
+			return
+		}
+	}
+
+	if f.opts.CheckTemplateDirectives {
+		f.checkTemplateDirectives(nodes)
+	}
+
+	if f.opts.BufferSize >= 0 {
+		bw := bufio.NewWriterSize(w, f.opts.BufferSize)
+		defer func() {
+			// flush even on errors so that partial output is not lost
+			if flushErr := bw.Flush(); err == nil {
+				err = flushErr
+			}
+		}()
+		w = bw
+	}
+
+	tw := &trailingNewlineWriter{w: w}
+	defer func() {
+		// runs before the buffer is flushed
+		if endErr := tw.end(f.finalLineEnding()); err == nil {
+			err = endErr
+		}
+	}()
+	w = tw
+
+	if f.opts.TrimTrailingWhitespace {
+		f.trimmer = &trailingSpaceWriter{w: w}
+		w = f.trimmer
+	}
+
+	if f.levels != nil {
+		f.levels.writer = w
+		w = f.levels
+	}
+
+	if f.opts.WrapPlainText && isPlainText(nodes) {
+		return f.printPlainText(w, nodes)
+	}
+
+	colAfter := uint(0)
+	// afterBlock is true when the last node printed, apart from whitespace and
+	// comments, is a block element, and blank counts the blank lines printed
+	// since the last node
+	afterBlock := false
+	blank := 0
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+		if err = f.checkContext(); err != nil {
+			return
+		}
+
+		if region := f.preservedRegion(nodes[i:]); region != nil {
+			if colAfter, err = f.printPreservedRegion(w, region, f.startLevel, colAfter); err != nil {
+				return
+			}
+			i += len(region) - 1
+			continue
+		}
+
+		if i > 0 && i < len(nodes)-1 {
+			if colAfter, err = f.printBlankLines(w, nodes[i-1], node, nodes[i+1], colAfter); err != nil {
+				return
+			}
+			blank += f.blankLines(nodes[i-1], node, nodes[i+1])
+		}
+
+		if f.opts.SeparateTopLevelElements && afterBlock && isBlockElement(node) && blank == 0 && f.opts.MaxBlankLines > 0 {
+			if colAfter, err = f.printNewLine(w, node, f.startLevel, colAfter); err != nil {
+				return
+			}
+		}
+
+		if colAfter, err = f.printNode(w, node, f.startLevel, colAfter); err != nil {
+			return
+		}
+
+		switch {
+		case node.Type == html.ElementNode:
+			afterBlock = isBlockElement(node)
+		case node.Type == html.TextNode && !isEmptyTextNode(node, 0, 0):
+			afterBlock = false
+		}
+		if !isEmptyTextNode(node, 0, 0) {
+			blank = 0
+		}
+	}
+	return
+}
+
+// Are all the nodes text?
+func isPlainText(nodes []*html.Node) bool {
+	for _, node := range nodes {
+		if node.Type != html.TextNode {
+			return false
+		}
+	}
+
+	return len(nodes) > 0
+}
+
+// Prints text nodes reflowed as a single paragraph.
+func (f *formatter) printPlainText(w io.Writer, nodes []*html.Node) error {
+	var text strings.Builder
+	for _, node := range nodes {
+		text.WriteString(escapedText(node))
+	}
+
+	s := strings.Join(strings.Fields(text.String()), " ")
+	if s == "" {
+		return nil
+	}
+
+	wrapper := acquireWordWrapper(w, WrapOptions{
+		Limit:         f.maxWidth(),
+		Indentation:   f.indentAtLevel(f.startLevel),
+		RuneWidth:     f.opts.RuneWidth,
+		GraphemeWidth: f.opts.GraphemeWidth,
+		OnWrap:        f.wrapReporter(nil),
+		LineEnding:    f.opts.LineEnding,
+	})
+	defer releaseWordWrapper(wrapper)
+	f.feedText(s, wrapper)
+	wrapper.FinalFlush()
+	_, err := f.printNewLine(w, nil, f.startLevel, 0)
+
+	return err
+}
+
+// Is this node a tag with no end tag such as <meta> or <br>?
+// http://www.w3.org/TR/html-markup/syntax.html#syntax-elements
+func isEmptyElement(n *html.Node, _ int, _ uint) bool {
+	switch n.DataAtom {
+	case atom.Area, atom.Base, atom.Br, atom.Col, atom.Command, atom.Embed,
+		atom.Hr, atom.Img, atom.Input, atom.Keygen, atom.Link,
+		atom.Meta, atom.Param, atom.Source, atom.Track, atom.Wbr:
+		return true
+	}
+
+	return false
+}
+
+// Is this node a phrasing content element that flows inline with text?
+func isInlineElement(n *html.Node, _ int, _ uint) bool {
+	switch n.DataAtom {
+	case atom.A, atom.Abbr, atom.B, atom.Bdi, atom.Bdo, atom.Br, atom.Button,
+		atom.Cite, atom.Code, atom.Data, atom.Dfn, atom.Em, atom.I, atom.Img,
+		atom.Input, atom.Kbd, atom.Label, atom.Mark, atom.Meter, atom.Output,
+		atom.Progress, atom.Q, atom.S, atom.Samp, atom.Select, atom.Small,
+		atom.Span, atom.Strong, atom.Sub, atom.Sup, atom.Textarea, atom.Time,
+		atom.U, atom.Var, atom.Wbr:
+		return true
+	}
+
+	return false
+}
+
+func isBreakElement(n *html.Node, _ int, _ uint) bool {
+	return n.DataAtom == atom.Br
+}
+
+func isWordBreakElement(n *html.Node, _ int, _ uint) bool {
+	return n.DataAtom == atom.Wbr
+}
+
+func (f *formatter) isVoidElement(n *html.Node, _ int, _ uint) bool {
+	return isElement(n) && f.classifier.IsVoid(n)
+}
+
+func (f *formatter) isNonVoidElement(n *html.Node, level int, col uint) bool {
+	return !f.isVoidElement(n, level, col)
+}
+
+func isSpecialContentElement(n *html.Node, _ int, _ uint) bool {
+	if n != nil {
+		switch n.DataAtom {
+		case atom.Style,
+			atom.Script:
+			return true
+		}
+	}
+	return false
+}
+
+func (f *formatter) isSpecialContentElement(n *html.Node, _ int, _ uint) bool {
+	return isElement(n) && f.classifier.IsSpecialContent(n)
+}
+
+func (f *formatter) isChildOfSpecialContentElement(n *html.Node, level int, col uint) bool {
+	return f.isSpecialContentElement(n.Parent, level, col)
+}
+
+func isScriptWithSrcAttribute(n *html.Node, _ int, _ uint) bool {
+	return n.DataAtom == atom.Script && hasSrcAttribute(n)
+}
+
+// Script types whose contents are HTML templates rather than code.
+var templateScriptTypes = map[string]bool{
+	"text/html":                  true,
+	"text/template":              true,
+	"text/x-template":            true,
+	"text/ng-template":           true,
+	"text/x-handlebars-template": true,
+}
+
+func isTemplateScript(n *html.Node, _ int, _ uint) bool {
+	return n.DataAtom == atom.Script && templateScriptTypes[strings.ToLower(strings.TrimSpace(getAttribute(n, "type")))]
+}
+
+func hasSrcAttribute(n *html.Node) bool {
+	return hasAttribute(n, "src")
+}
+
+func getAttribute(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttribute(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// The attribute that, set to "ignore", marks an element whose subtree is
+// printed as is.
+const ignoreAttribute = "data-formathtml"
+
+func isIgnoredElement(n *html.Node, _ int, _ uint) bool {
+	return strings.EqualFold(strings.TrimSpace(getAttribute(n, ignoreAttribute)), "ignore")
+}
+
+func isParagraphLike(n *html.Node, _ int, _ uint) bool {
+	switch n.DataAtom {
+	case atom.P, atom.Caption, atom.Figcaption:
+		return true
+	}
+
+	return false
+}
+
+// Is the whitespace at the edges of the text of this element kept? It is for
+// inline elements, where it separates them from the text around them, except
+// for interactive ones, which keep it only when PreserveInteractiveWhitespace
+// is set.
+func (f *formatter) keepsEdgeSpace(n *html.Node) bool {
+	if isInteractiveElement(n, 0, 0) {
+		return f.opts.PreserveInteractiveWhitespace
+	}
+
+	return isInlineElement(n, 0, 0)
+}
+
+// Is this node an interactive element whose rendering can depend on the
+// whitespace around its text?
+func isInteractiveElement(n *html.Node, _ int, _ uint) bool {
+	if n != nil {
+		switch n.DataAtom {
+		case atom.A, atom.Button, atom.Label, atom.Summary:
+			return true
+		}
+	}
+	return false
+}
+
+// Is this a preformatted element, including the legacy xmp, listing and
+// plaintext elements?
+func isPre(n *html.Node, _ int, _ uint) bool {
+	switch n.DataAtom {
+	case atom.Pre, atom.Xmp, atom.Listing, atom.Plaintext:
+		return true
+	}
+
+	return false
+}
+
+// Is this a legacy preformatted element whose content is raw text that is
+// never escaped?
+func isRawPre(n *html.Node, _ int, _ uint) bool {
+	return n != nil && (n.DataAtom == atom.Xmp || n.DataAtom == atom.Plaintext)
+}
+
+// Is this a plaintext element? Everything after its opening tag is its
+// content so it has no end tag.
+func isPlaintext(n *html.Node, _ int, _ uint) bool {
+	return n.DataAtom == atom.Plaintext
+}
+
+// Does this element have an inline style that keeps its whitespace, like
+// white-space: pre?
+func hasPreWhiteSpaceStyle(n *html.Node, _ int, _ uint) bool {
+	for _, declaration := range strings.Split(getAttribute(n, "style"), ";") {
+		property, value, found := strings.Cut(declaration, ":")
+		if !found || !strings.EqualFold(strings.TrimSpace(property), "white-space") {
+			continue
+		}
+
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+		switch strings.ToLower(value) {
+		case "pre", "pre-wrap", "pre-line":
+			return true
+		}
+	}
+
+	return false
+}
+
+func isTextarea(n *html.Node, _ int, _ uint) bool {
+	return n.DataAtom == atom.Textarea
+}
+
+// Does the content of this pre, listing or textarea element begin with
+// whitespace? The parser drops a newline right after these opening tags, so
+// one is printed back to keep the leading whitespace as written.
+func hasLeadingContentWhitespace(n *html.Node, _ int, _ uint) bool {
+	switch n.DataAtom {
+	case atom.Pre, atom.Listing, atom.Textarea:
+	default:
+		return false
+	}
+
+	c := n.FirstChild
+	return c != nil && c.Type == html.TextNode && strings.TrimLeft(c.Data, " \t\r\n") != c.Data
+}
+
+// Is this node an element that is not in the known HTML element set, such as a
+// custom element?
+func isUnknownElement(n *html.Node, _ int, _ uint) bool {
+	return n.Type == html.ElementNode && n.Namespace == "" && atom.Lookup([]byte(n.Data)) == 0
+}
+
+func isEmptyTextNode(n *html.Node, _ int, _ uint) bool {
+	return n.Type == html.TextNode && trimHTMLSpace(n.Data) == ""
+}
+
+func getFirstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func hasSingleTextChild(n *html.Node, _ int, _ uint) bool {
+	return n != nil && n.FirstChild != nil && n.FirstChild == n.LastChild && n.FirstChild.Type == html.TextNode
+}
+
+// Does this node have no children at all?
+func hasNoChildren(n *html.Node, _ int, _ uint) bool {
+	return n.FirstChild == nil
+}
+
+// Is this element printed like an empty one? Whitespace is only significant
+// in inline elements, so other elements that hold just whitespace are.
+func isBlankElement(n *html.Node, level int, col uint) bool {
+	return hasNoChildren(n, level, col) || !hasContent(n, level, col) && !isInlineElement(n, level, col)
+}
+
+// Does this node have children other than text that is just whitespace?
+func hasContent(n *html.Node, level int, col uint) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !isEmptyTextNode(c, level, col) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Does this node have a single text child that is not just whitespace? An
+// element with only whitespace is printed like an empty one, unless it is
+// inline.
+func hasSingleNonEmptyTextChild(n *html.Node, level int, col uint) bool {
+	return hasSingleTextChild(n, level, col) && !isEmptyTextNode(n.FirstChild, level, col)
+}
+
+func isSingleTextChild(n *html.Node, level int, col uint) bool {
+	return hasSingleTextChild(n.Parent, level, col)
+}
+
+func isHtmlElement(n *html.Node, _ int, _ uint) bool {
+	return n.DataAtom == atom.Html
+}
+
+// Is this the html element with its head and body printed at its own level?
+func (f *formatter) isUnindentedHtmlElement(n *html.Node, level int, col uint) bool {
+	return !f.opts.IndentHtmlChildren && isHtmlElement(n, level, col)
+}
+
+func (f *formatter) isParagraphLike(n *html.Node, _ int, _ uint) bool {
+	return isElement(n) && f.classifier.IsParagraphLike(n)
+}
+
+func (f *formatter) isChildOfParagraph(n *html.Node, level int, col uint) bool {
+	return f.isParagraphLike(n.Parent, level, col)
+}
+
+func (f *formatter) isPreformatted(n *html.Node, _ int, _ uint) bool {
+	return isElement(n) && f.classifier.IsPreformatted(n)
+}
+
+func isElement(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode
+}
+
+func noNextSibling(n *html.Node, _ int, _ uint) bool {
+	return n.NextSibling == nil
+}
+
+func noPrevSibling(n *html.Node, _ int, _ uint) bool {
+	return n.PrevSibling == nil
+}
+
+func nextSiblingIsNotPunctuation(n *html.Node, _ int, _ uint) bool {
+	return !startsWithPunctuation(n.NextSibling, 0, 0)
+}
+
+// Does the text node start with punctuation or a symbol, like a period, a
+// closing quote or bracket, an ellipsis or an emoji, that is printed right
+// after the element before it? Text that starts with whitespace does not.
+func startsWithPunctuation(n *html.Node, _ int, _ uint) bool {
+	if n == nil || n.Type != html.TextNode {
+		return false
+	}
+
+	r := getFirstRune(n.Data)
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func nextSiblingIsElementNode(n *html.Node, _ int, _ uint) bool {
+	return n.NextSibling.Type == html.ElementNode
+}
+
+func (f *formatter) printNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	colAfter = col
+	switch n.Type {
+	case html.TextNode:
+		return f.printTextNode(w, n, level, col)
+	case html.ElementNode:
+		return f.printElementNode(w, n, level, col)
+	case html.CommentNode:
+		return f.printCommentNode(w, n, level, col)
+	case html.DoctypeNode:
+		return f.printDoctypeNode(w, n, level, col)
+	case html.DocumentNode:
+		return f.printChildren(w, n, level, col)
+	}
+	return
+}
+
+func (f *formatter) printDoctypeNode(w io.Writer, n *html.Node, _ int, _ uint) (colAfter uint, err error) {
+	if f.opts.CanonicalLegacyDoctype {
+		n = canonicalLegacyDoctype(n)
+	}
+
+	if f.opts.DoctypeCase == DoctypeAsIs {
+		err = html.Render(w, n)
+	} else {
+		var b strings.Builder
+		if err = html.Render(&b, n); err != nil {
+			return
+		}
+		_, err = io.WriteString(w, caseDoctype(b.String(), f.opts.DoctypeCase))
+	}
+	if err != nil {
+		return
+	}
+
+	return f.printNewLine(w, n, 0, 0)
+}
+
+// DoctypeCase is the case the keywords of a doctype are printed in.
+type DoctypeCase int
+
+const (
+	// DoctypeAsIs prints doctypes like <!DOCTYPE html>.
+	DoctypeAsIs DoctypeCase = iota
+	// DoctypeUpper prints doctypes like <!DOCTYPE HTML>.
+	DoctypeUpper
+	// DoctypeLower prints doctypes like <!doctype html>.
+	DoctypeLower
+)
+
+// Changes the case of the rendered doctype outside of its quoted identifiers.
+func caseDoctype(s string, c DoctypeCase) string {
+	var b strings.Builder
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case c == DoctypeUpper:
+			r = unicode.ToUpper(r)
+		case c == DoctypeLower:
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Public identifiers of the legacy doctypes from the HTML and XHTML specs.
+var legacyDoctypePublicIDs = map[string]bool{
+	"-//ietf//dtd html 2.0//en":                      true,
+	"-//w3c//dtd html 3.2 final//en":                 true,
+	"-//w3c//dtd html 4.0//en":                       true,
+	"-//w3c//dtd html 4.0 transitional//en":          true,
+	"-//w3c//dtd html 4.0 frameset//en":              true,
+	"-//w3c//dtd html 4.01//en":                      true,
+	"-//w3c//dtd html 4.01 transitional//en":         true,
+	"-//w3c//dtd html 4.01 frameset//en":             true,
+	"-//w3c//dtd xhtml 1.0 strict//en":               true,
+	"-//w3c//dtd xhtml 1.0 transitional//en":         true,
+	"-//w3c//dtd xhtml 1.0 frameset//en":             true,
+	"-//w3c//dtd xhtml 1.1//en":                      true,
+	"-//w3c//dtd xhtml basic 1.1//en":                true,
+	"-//w3c//dtd html 4.01 transitional//en//legacy": true,
+}
+
+// Returns a copy of the doctype node with whitespace runs in its identifiers
+// collapsed to single spaces if it is a known legacy doctype. Other doctypes
+// are returned unchanged.
+func canonicalLegacyDoctype(n *html.Node) *html.Node {
+	attrs := make([]html.Attribute, len(n.Attr))
+	known := false
+	for i, a := range n.Attr {
+		attrs[i] = a
+		attrs[i].Val = strings.Join(strings.Fields(a.Val), " ")
+		if a.Key == "public" {
+			known = legacyDoctypePublicIDs[strings.ToLower(attrs[i].Val)]
+		}
+	}
+
+	if !known {
+		return n
+	}
+
+	canonical := *n
+	canonical.Attr = attrs
+
+	return &canonical
+}
+
+// Is this a conditional comment like <!--[if IE]> or its <!--<![endif]-->
+// closer?
+func isConditionalComment(n *html.Node, _ int, _ uint) bool {
+	data := strings.TrimSpace(n.Data)
+	return strings.HasPrefix(data, "[if") || strings.HasPrefix(data, "<![endif]")
+}
+
+func (f *formatter) isStrippedComment(n *html.Node) bool {
+	return f.opts.StripComments && n.Type == html.CommentNode && !isConditionalComment(n, 0, 0)
+}
+
+func (f *formatter) printCommentNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	if f.isStrippedComment(n) {
+		return col, nil
+	}
+
+	if colAfter, err = f.printIndent(w, n, level, col); err != nil {
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "<!--%s-->%s", n.Data, f.lineEnding())
+
+	// the comment ends its line so whatever follows, even punctuation, starts
+	// on a new one and is indented
+	return 0, err
+}
+
+// Returns the text of a text node escaped so that it reads back as the same
+// text. The text of raw text elements like iframe and xmp is returned as is
+// since the parser does not decode it.
+func escapedText(n *html.Node) string {
+	if isUndecodedTextElement(n.Parent) {
+		return n.Data
+	}
+
+	return html.EscapeString(n.Data)
+}
+
+// Elements whose contents the parser reads as text without decoding character
+// references.
+func isUndecodedTextElement(n *html.Node) bool {
+	if n == nil || n.Type != html.ElementNode || n.Namespace != "" {
+		return false
+	}
+
+	switch n.DataAtom {
+	case atom.Iframe, atom.Noembed, atom.Noframes, atom.Plaintext, atom.Script, atom.Style, atom.Xmp:
+		return true
+	}
+
+	return false
+}
+
+// The whitespace characters of HTML. Unlike unicode.IsSpace these do not
+// include non-breaking spaces, which are content.
+const htmlSpace = " \t\n\f\r"
+
+func trimHTMLSpace(s string) string {
+	return strings.Trim(s, htmlSpace)
+}
+
+// Replaces each run of whitespace with a single space.
+func collapseHTMLSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if strings.ContainsRune(htmlSpace, r) {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Is this text between elements made only of whitespace, including
+// non-breaking spaces, that can be left out?
+func (f *formatter) isInterElementWhitespace(n *html.Node, level int, col uint) bool {
+	return f.opts.CollapseInterElementWhitespace && n.Type == html.TextNode &&
+		!isSingleTextChild(n, level, col) && strings.TrimSpace(n.Data) == ""
+}
+
+func (f *formatter) printTextNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	if f.isInterElementWhitespace(n, level, col) {
+		return col, nil
+	}
+
+	s := escapedText(n)
+	if f.opts.CollapseWhitespace && !f.isChildOfSpecialContentElement(n, level, col) {
+		s = collapseHTMLSpace(s)
+	}
+	if isSingleTextChild(n, level, col) && f.keepsEdgeSpace(n.Parent) {
+		s = collapseEdgeSpace(s)
+	} else {
+		s = trimHTMLSpace(s)
+	}
+	if s != "" {
+		colAfter, err = runPrinters(
+			printIf(
+				allAre(
+					not(f.isChildOfSpecialContentElement),
+					not(isSingleTextChild),
+					isAtFirstColumn,
+				),
+				f.printIndent,
+			),
+		)(w, n, level, col)
+		if err != nil {
+			return
+		}
+
+		if f.isChildOfSpecialContentElement(n, level, colAfter) {
+			for _, t := range f.specialContentLines(n) {
+				if _, err = fmt.Fprint(w, f.lineEnding()); err != nil {
+					return
+				}
+				colAfter = 0 // after a new line
+				if colAfter, err = f.printIndent(w, n, level, colAfter); err != nil {
+					return
+				}
+				if _, err = fmt.Fprint(w, t); err != nil {
+					return
+				}
+			}
+			if _, err = fmt.Fprint(w, f.lineEnding()); err != nil {
+				return
+			}
+		} else {
+			if _, err = fmt.Fprint(w, s); err != nil {
+				return
+			}
+			if !isSingleTextChild(n, level, colAfter) {
+				if colAfter, err = f.printNewLine(w, n, level, colAfter); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// Returns the lines of the content of a script or style element as they
+// should be printed. The content of those elements in SVG is not raw text, so
+// it is printed as a CDATA section if it has characters that would otherwise
+// need escaping.
+func (f *formatter) specialContentLines(n *html.Node) []string {
+	if isJSONScript(n.Parent, 0, 0) {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, bytes.TrimSpace([]byte(n.Data)), "", f.opts.Indent); err == nil {
+			return strings.Split(indented.String(), "\n")
+		}
+	}
+
+	if !isForeignElement(n.Parent) {
+		// script and style content is raw text that is never escaped
+		return contentLines(n.Data)
+	}
+
+	if !strings.ContainsAny(n.Data, "<>&") || strings.Contains(n.Data, "]]>") {
+		return contentLines(html.EscapeString(n.Data))
+	}
+
+	lines := contentLines(n.Data)
+	if len(lines) == 1 {
+		return []string{"<![CDATA[" + lines[0] + "]]>"}
+	}
+
+	return append(append([]string{"<![CDATA["}, lines...), "]]>")
+}
+
+// Is this a script element holding JSON data like JSON-LD?
+func isJSONScript(n *html.Node, _ int, _ uint) bool {
+	if n == nil || n.DataAtom != atom.Script {
+		return false
+	}
+
+	mediaType := strings.ToLower(strings.TrimSpace(getAttribute(n, "type")))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == "importmap"
+}
+
+// Is this an element in foreign content like SVG or MathML?
+func isForeignElement(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode && (n.Namespace == "svg" || n.Namespace == "math")
+}
+
+// Returns the lines of script or style content without the surrounding blank
+// lines and the indentation the lines have in common, so that reindenting
+// formatted content leaves it as is.
+func contentLines(s string) []string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return dedentLines(lines)
+}
+
+// The <pre> tag indicates that the text within it should always be formatted
+// as is. See https://github.com/ericchiang/pup/issues/33
+func (f *formatter) printPreChild(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	if err = f.checkContext(); err != nil {
+		return col, err
+	}
+
+	switch n.Type {
+	case html.TextNode:
+		return runPrinters(
+			f.printData,
+			printDelegateChildren(f.printPreChild),
+		)(w, n, level, col)
+
+	case html.ElementNode:
+		return runPrinters(
+			f.printOpeningTag,
+			printIf(f.isNonVoidElement, printDelegateChildren(f.printPreChild)),
+			printIf(f.isNonVoidElement, f.printClosingTag),
+		)(w, n, level, col)
+
+	case html.CommentNode:
+		return f.printCommentNode(w, n, level, col)
+
+	case html.DoctypeNode, html.DocumentNode:
+		return printDelegateChildren(f.printPreChild)(w, n, level, col)
+	}
+
+	return
+}
+
+// Prints the element and its subtree with its contents kept as is.
+func (f *formatter) printVerbatimElement(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	return runPrinters(
+		f.printIndent,
+		f.printOpeningTag,
+		printIf(hasLeadingContentWhitespace, f.printNewLine),
+		f.keepTrailingWhitespace(printDelegateChildren(f.printPreChild)),
+		printIf(not(isPlaintext), runPrinters(
+			f.printClosingTag,
+			f.printNewLine,
+		)),
+	)(w, n, level, col)
+}
+
+// Returns the name the element is printed with.
+func (f *formatter) tagName(n *html.Node) string {
+	if f.opts.LowercaseTags && n.Namespace == "" {
+		if name := strings.ToLower(n.Data); htmlElements[name] {
+			return name
+		}
+	}
+
+	return n.Data
+}
+
+// The names of the HTML elements, including obsolete ones the parser still
+// knows, that LowercaseTags prints in lowercase.
+var htmlElements = map[string]bool{
+	"a": true, "abbr": true, "acronym": true, "address": true, "applet": true,
+	"area": true, "article": true, "aside": true, "audio": true, "b": true,
+	"base": true, "basefont": true, "bdi": true, "bdo": true, "bgsound": true,
+	"big": true, "blink": true, "blockquote": true, "body": true, "br": true,
+	"button": true, "canvas": true, "caption": true, "center": true,
+	"cite": true, "code": true, "col": true, "colgroup": true, "data": true,
+	"datalist": true, "dd": true, "del": true, "details": true, "dfn": true,
+	"dialog": true, "dir": true, "div": true, "dl": true, "dt": true,
+	"em": true, "embed": true, "fieldset": true, "figcaption": true,
+	"figure": true, "font": true, "footer": true, "form": true, "frame": true,
+	"frameset": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "head": true, "header": true, "hgroup": true,
+	"hr": true, "html": true, "i": true, "iframe": true, "image": true,
+	"img": true, "input": true, "ins": true, "isindex": true, "kbd": true,
+	"keygen": true, "label": true, "legend": true, "li": true, "link": true,
+	"listing": true, "main": true, "map": true, "mark": true, "marquee": true,
+	"menu": true, "menuitem": true, "meta": true, "meter": true, "nav": true,
+	"nobr": true, "noembed": true, "noframes": true, "noscript": true,
+	"object": true, "ol": true, "optgroup": true, "option": true,
+	"output": true, "p": true, "param": true, "picture": true,
+	"plaintext": true, "pre": true, "progress": true, "q": true, "rb": true,
+	"rp": true, "rt": true, "rtc": true, "ruby": true, "s": true, "samp": true,
+	"script": true, "search": true, "section": true, "select": true,
+	"slot": true, "small": true, "source": true, "spacer": true, "span": true,
+	"strike": true, "strong": true, "style": true, "sub": true,
+	"summary": true, "sup": true, "table": true, "tbody": true, "td": true,
+	"template": true, "textarea": true, "tfoot": true, "th": true,
+	"thead": true, "time": true, "title": true, "tr": true, "track": true,
+	"tt": true, "u": true, "ul": true, "var": true, "video": true,
+	"wbr": true, "xmp": true,
+}
+
+func (f *formatter) printOpeningTag(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	f.checkElement(n)
+	if perLine := f.opts.MaxAttributesPerLine; perLine > 0 && len(f.attributes(n)) > perLine {
+		return f.printWrappedOpeningTag(w, n, level)
+	}
+	if !f.opts.WrapAttributes || len(n.Attr) == 0 {
+		return f.printOneLineOpeningTag(w, n, level, col)
+	}
+
+	var b bytes.Buffer
+	if colAfter, err = f.printOneLineOpeningTag(&b, n, level, col); err != nil {
+		return
+	}
+	if colAfter <= f.maxWidth() && !strings.Contains(b.String(), f.lineEnding()) {
+		_, err = w.Write(b.Bytes())
+		return
+	}
+
+	return f.printWrappedOpeningTag(w, n, level)
+}
+
+func (f *formatter) printOneLineOpeningTag(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	name := f.tagName(n)
+	colAfter = col + f.stringWidth(name) + 1
+	if _, err = fmt.Fprintf(w, "<%s", name); err != nil {
+		return
+	}
+
+	for _, a := range f.attributes(n) {
+		if _, err = fmt.Fprint(w, " "); err != nil {
+			return
+		}
+		if colAfter, err = f.printAttribute(w, n, a, level, colAfter+1); err != nil {
+			return
+		}
+	}
+
+	end := f.openingTagEnd(n)
+	colAfter += f.stringWidth(end)
+	_, err = fmt.Fprint(w, end)
+
+	return
+}
+
+// Prints the opening tag with each attribute, or each MaxAttributesPerLine
+// attributes, on its own line indented under the element and the closing
+// bracket on a line of its own.
+func (f *formatter) printWrappedOpeningTag(w io.Writer, n *html.Node, level int) (colAfter uint, err error) {
+	if _, err = fmt.Fprintf(w, "<%s", f.tagName(n)); err != nil {
+		return
+	}
+
+	perLine := f.opts.MaxAttributesPerLine
+	if perLine <= 0 {
+		perLine = 1
+	}
+
+	indent := f.indentAtLevel(level + 1)
+	for i, a := range f.attributes(n) {
+		if i%perLine == 0 {
+			_, err = fmt.Fprint(w, f.lineEnding(), indent)
+			colAfter = f.stringWidth(indent)
+		} else {
+			_, err = fmt.Fprint(w, " ")
+			colAfter++
+		}
+		if err != nil {
+			return
+		}
+		if colAfter, err = f.printAttribute(w, n, a, level+1, colAfter); err != nil {
+			return
+		}
+	}
+
+	end := strings.TrimLeft(f.openingTagEnd(n), " ")
+	indent = f.indentAtLevel(level)
+	_, err = fmt.Fprint(w, f.lineEnding(), indent, end)
+
+	return f.stringWidth(indent) + f.stringWidth(end), err
+}
+
+// Returns how the opening tag of the element is closed.
+func (f *formatter) openingTagEnd(n *html.Node) string {
+	if f.opts.SelfCloseVoidElements && f.isVoidElement(n, 0, 0) {
+		return " />"
+	}
+
+	return ">"
+}
+
+// Prints the attribute starting at the given column. The space before it is
+// printed by the caller.
+func (f *formatter) printAttribute(w io.Writer, n *html.Node, a html.Attribute, level int, col uint) (colAfter uint, err error) {
+	if f.isCollapsibleBooleanAttribute(a) {
+		colAfter = col + f.stringWidth(a.Key)
+		_, err = fmt.Fprint(w, a.Key)
+		return
+	}
+
+	if f.opts.PreserveMultilineAttributes && strings.Contains(a.Val, "\n") {
+		return f.printMultilineAttribute(w, a, level, col)
+	}
+
+	quote := f.attributeQuote()
+	if tokens, ok := f.attributeTokens(n, a); ok {
+		if _, err = fmt.Fprintf(w, `%s=%c`, a.Key, quote); err != nil {
+			return
+		}
+		colAfter, err = f.printWrappedTokens(w, n, tokens, level+1, col+f.stringWidth(a.Key)+2)
+		if err != nil {
+			return
+		}
+		colAfter++
+		_, err = fmt.Fprintf(w, "%c", quote)
+		return
+	}
+
+	val := f.escapeAttribute(a.Val)
+	colAfter = col + f.stringWidth(a.Key) + f.stringWidth(val) + 3 // 3 is for the equal sign and quotes
+	_, err = fmt.Fprintf(w, `%s=%c%s%c`, a.Key, quote, val, quote)
+
+	return
+}
+
+// Prints an attribute whose value has several lines. The lines after the first
+// keep their indentation relative to each other but are reindented to line up
+// with the element.
+func (f *formatter) printMultilineAttribute(w io.Writer, a html.Attribute, level int, col uint) (colAfter uint, err error) {
+	quote := f.attributeQuote()
+	if quote == '"' && strings.Contains(a.Val, `"`) && !strings.Contains(a.Val, "'") {
+		quote = '\''
+	}
+
+	lines := strings.Split(escapeAttributeValue(a.Val, quote), "\n")
+	rest := dedentLines(lines[1:])
+	indent := f.indentAtLevel(level)
+	for i, line := range rest {
+		if line != "" {
+			rest[i] = indent + line
+		}
+	}
+
+	value := strings.Join(append(lines[:1], rest...), f.lineEnding())
+	if _, err = fmt.Fprintf(w, "%s=%c%s%c", a.Key, quote, value, quote); err != nil {
+		return
+	}
+
+	if len(rest) == 0 {
+		return col + f.stringWidth(a.Key) + f.stringWidth(value) + 3, nil
+	}
+
+	return f.stringWidth(rest[len(rest)-1]) + 2, nil // 2 is for the closing quote and bracket
+}
+
+// Escapes the characters of an attribute value that cannot appear as is
+// between the given quotes.
+func escapeAttributeValue(s string, quote byte) string {
+	escaped := strings.ReplaceAll(s, "&", "&amp;")
+	if quote == '\'' {
+		return strings.ReplaceAll(escaped, "'", "&#39;")
+	}
+
+	return strings.ReplaceAll(escaped, `"`, "&#34;")
+}
+
+// Removes the leading whitespace the non-blank lines have in common.
+func dedentLines(lines []string) []string {
+	common, found := "", false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if !found {
+			common, found = indent, true
+			continue
+		}
+		for !strings.HasPrefix(indent, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	dedented := make([]string, len(lines))
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			dedented[i] = strings.TrimPrefix(line, common)
+		}
+	}
+
+	return dedented
+}
+
+func (f *formatter) isCollapsibleBooleanAttribute(a html.Attribute) bool {
+	if !f.opts.CollapseBooleanAttributes {
+		return false
+	}
+
+	return f.isBooleanAttribute(a) && (a.Val == "" || strings.EqualFold(a.Val, a.Key))
 }
 
-func isChildOfParagraph(n *html.Node, level int, col uint) bool {
-	return isParagraphLike(n.Parent, level, col)
-}
+func (f *formatter) isBooleanAttribute(a html.Attribute) bool {
+	key := strings.ToLower(a.Key)
+	if strings.HasPrefix(key, "aria-") || enumeratedAttributes[key] || f.nonBooleanAttributes[key] {
+		return false
+	}
 
-func noNextSibling(n *html.Node, _ int, _ uint) bool {
-	return n.NextSibling == nil
+	return f.booleanAttributes[key]
 }
 
-func noPrevSibling(n *html.Node, _ int, _ uint) bool {
-	return n.PrevSibling == nil
+func (f *formatter) isTokenizedAttribute(a html.Attribute) bool {
+	return f.tokenizedAttributes[strings.ToLower(a.Key)]
 }
 
-func nextSiblingIsNotPunctuation(n *html.Node, _ int, _ uint) bool {
-	return !unicode.IsPunct(getFirstRune(n.NextSibling.Data))
-}
+// Returns the escaped tokens of an attribute value that may be wrapped between
+// tokens, and whether the attribute is one that is wrapped that way.
+func (f *formatter) attributeTokens(n *html.Node, a html.Attribute) ([]string, bool) {
+	if f.isTokenizedAttribute(a) {
+		return f.escapedTokens(strings.Fields(a.Val)), true
+	}
 
-func nextSiblingIsElementNode(n *html.Node, _ int, _ uint) bool {
-	return n.NextSibling.Type == html.ElementNode
+	if f.opts.WrapSVGAttributes && n.Namespace == "svg" {
+		switch a.Key {
+		case "transform":
+			return f.escapedTokens(transformTokens(a.Val)), true
+		case "style":
+			return f.escapedTokens(styleTokens(a.Val)), true
+		}
+	}
+
+	return nil, false
 }
 
-func printNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
-	colAfter = col
-	switch n.Type {
-	case html.TextNode:
-		return printTextNode(w, n, level, col)
-	case html.ElementNode:
-		return printElementNode(w, n, level, col)
-	case html.CommentNode:
-		return printCommentNode(w, n, level, col)
-	case html.DoctypeNode:
-		return printDoctypeNode(w, n, level, col)
-	case html.DocumentNode:
-		return printChildren(w, n, level, col)
+func (f *formatter) escapedTokens(tokens []string) []string {
+	for i, token := range tokens {
+		tokens[i] = f.escapeAttribute(token)
 	}
-	return
+
+	return tokens
 }
 
-func printDoctypeNode(w io.Writer, n *html.Node, _ int, _ uint) (colAfter uint, err error) {
-	if err = html.Render(w, n); err != nil {
-		return
+func (f *formatter) attributeQuote() byte {
+	if f.opts.AttributeQuote == '\'' {
+		return '\''
 	}
 
-	return printNewLine(w, n, 0, 0)
+	return '"'
 }
 
-func printCommentNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
-	if colAfter, err = printIndent(w, n, level, col); err != nil {
-		return
+// Escapes an attribute value to be printed between the configured quotes.
+func (f *formatter) escapeAttribute(s string) string {
+	escaped := html.EscapeString(s)
+	if f.attributeQuote() == '\'' {
+		return strings.ReplaceAll(escaped, "&#34;", `"`)
 	}
 
-	colAfter = uint(7 + utf8.RuneCountInString(n.Data))
-	_, err = fmt.Fprintf(w, "<!--%s-->\n", n.Data)
-
-	return
+	return escaped
 }
 
-func getRenderedStringData(n *html.Node) string {
-	var bbuff bytes.Buffer
-	html.Render(&bbuff, n)
-	return bbuff.String()
+// Splits an SVG transform list like "translate(10, 20) rotate(45)" into its
+// transform functions.
+func transformTokens(s string) []string {
+	var tokens []string
+	depth := 0
+	start := -1
+	for i, r := range s {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case unicode.IsSpace(r) && depth == 0:
+			if start >= 0 {
+				tokens = append(tokens, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, s[start:])
+	}
+
+	return tokens
 }
 
-func printTextNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
-	s := getRenderedStringData(n)
-	s = strings.TrimSpace(s)
-	if s != "" {
-		colAfter, err = runPrinters(
-			printIf(
-				allAre(
-					not(isChildOfSpecialContentElement),
-					not(isSingleTextChild),
-					conditionWithContext(s, func(n *html.Node, str string) bool {
-						return noPrevSibling(n, level, col) || !unicode.IsPunct(getFirstRune(s))
-					}),
-				),
-				printIndent,
-			),
-		)(w, n, level, col)
-		if err != nil {
-			return
+// Splits an inline style into its declarations, each ending with the semicolon
+// that separates it from the next.
+func styleTokens(s string) []string {
+	var tokens []string
+	declarations := strings.Split(s, ";")
+	for i, declaration := range declarations {
+		declaration = strings.TrimSpace(declaration)
+		if declaration == "" {
+			continue
 		}
+		if i < len(declarations)-1 {
+			declaration += ";"
+		}
+		tokens = append(tokens, declaration)
+	}
 
-		if isChildOfSpecialContentElement(n, level, colAfter) {
-			scanner := bufio.NewScanner(strings.NewReader(s))
-			for scanner.Scan() {
-				t := scanner.Text()
-				if _, err = fmt.Fprintln(w); err != nil {
-					return
-				}
-				colAfter = 0 // after a new line
-				if colAfter, err = printIndent(w, n, level, colAfter); err != nil {
+	return tokens
+}
+
+// Prints tokens separated by a space. A token that would go past the wrapping
+// limit is moved to a new line indented at the given level instead.
+func (f *formatter) printWrappedTokens(w io.Writer, n *html.Node, tokens []string, level int, col uint) (colAfter uint, err error) {
+	colAfter = col
+	lineStart := 0
+	for i, token := range tokens {
+		width := f.stringWidth(token)
+		if i > 0 {
+			if colAfter+width+1 > f.maxWidth() {
+				f.reportWrap(n, strings.Join(tokens[lineStart:i], " "))
+				lineStart = i
+				if colAfter, err = f.printNewLine(w, nil, level, colAfter); err != nil {
 					return
 				}
-				if _, err = fmt.Fprint(w, t); err != nil {
+				if colAfter, err = f.printIndent(w, nil, level, colAfter); err != nil {
 					return
 				}
-			}
-			if err = scanner.Err(); err != nil {
-				return
-			}
-			if _, err = fmt.Fprintln(w); err != nil {
-				return
-			}
-		} else {
-			if _, err = fmt.Fprint(w, s); err != nil {
-				return
-			}
-			if !isSingleTextChild(n, level, colAfter) {
-				if colAfter, err = printNewLine(w, n, level, colAfter); err != nil {
+			} else {
+				if _, err = fmt.Fprint(w, " "); err != nil {
 					return
 				}
+				colAfter++
 			}
 		}
+
+		if _, err = fmt.Fprint(w, token); err != nil {
+			return
+		}
+		colAfter += width
 	}
+
 	return
 }
 
-// The <pre> tag indicates that the text within it should always be formatted
-// as is. See https://github.com/ericchiang/pup/issues/33
-func printPreChild(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
-	switch n.Type {
-	case html.TextNode:
-		return runPrinters(
-			printData,
-			printDelegateChildren(printPreChild),
-		)(w, n, level, col)
-
-	case html.ElementNode:
-		return runPrinters(
-			printOpeningTag,
-			printIf(isNonEmptyElement, printDelegateChildren(printPreChild)),
-			printIf(isNonEmptyElement, printClosingTag),
-		)(w, n, level, col)
+func (f *formatter) passOpeningTag(n *html.Node, wrapper *WordWrapper) (colAfter uint, err error) {
+	f.checkElement(n)
+	wrapper.AddWord("<" + f.tagName(n))
+	attrs := f.attributes(n)
+	for _, a := range attrs {
+		if f.isCollapsibleBooleanAttribute(a) {
+			wrapper.AddSpaces(" ")
+			wrapper.AddWord(a.Key)
+			continue
+		}
 
-	case html.CommentNode:
-		return printCommentNode(w, n, level, col)
+		quote := string(f.attributeQuote())
+		if tokens, ok := f.attributeTokens(n, a); ok {
+			wrapper.AddSpaces(" ")
+			passTokens(a.Key+"="+quote, tokens, quote, wrapper)
+			continue
+		}
 
-	case html.DoctypeNode, html.DocumentNode:
-		return printDelegateChildren(printPreChild)(w, n, level, col)
+		val := f.escapeAttribute(a.Val)
+		wrapper.AddSpaces(" ")
+		wrapper.AddWord(a.Key + "=" + quote + val + quote)
 	}
+	// Allows breaking before the end bracket if adding it would exceed the
+	// limit. Tags without attributes are never broken, so that nested tags
+	// like <strong><em> stay together with the text they hold, except <wbr>,
+	// which marks where long words may be broken without adding a space.
+	end := f.openingTagEnd(n)
+	if space := end[:len(end)-len(strings.TrimLeft(end, " "))]; space != "" || len(attrs) > 0 || isWordBreakElement(n, 0, 0) {
+		wrapper.AddSpaces(space)
+	}
+	wrapper.AddWord(strings.TrimLeft(end, " "))
 
-	return
+	return wrapper.Column, nil
 }
 
-func printOpeningTag(w io.Writer, n *html.Node, _ int, col uint) (colAfter uint, err error) {
-	colAfter = col + uint(len(n.Data)+2) // 2 is for the angled brackets on both ends
-	if _, err = fmt.Fprintf(w, "<%s", n.Data); err != nil {
+// Adds the tokens as separate words so that the wrapper can break between them.
+func passTokens(prefix string, tokens []string, suffix string, wrapper *WordWrapper) {
+	if len(tokens) == 0 {
+		wrapper.AddWord(prefix + suffix)
 		return
 	}
 
-	for _, a := range n.Attr {
-		val := html.EscapeString(a.Val)
-		colAfter += uint(len(a.Key) + len(val))
-		if _, err = fmt.Fprintf(w, ` %s="%s"`, a.Key, val); err != nil {
-			return
+	for i, token := range tokens {
+		if i == 0 {
+			token = prefix + token
+		} else {
+			wrapper.AddSpaces(" ")
 		}
+		if i == len(tokens)-1 {
+			token += suffix
+		}
+		wrapper.AddWord(token)
 	}
-
-	_, err = fmt.Fprint(w, ">")
-
-	return
-}
-
-func passOpeningTag(n *html.Node, wrapper *WordWrapper) (colAfter uint, err error) {
-	wrapper.AddWord("<" + n.Data)
-	for _, a := range n.Attr {
-		val := html.EscapeString(a.Val)
-		wrapper.AddSpaces(" ")
-		wrapper.AddWord(fmt.Sprintf(`%s="%s"`, a.Key, val))
-	}
-	wrapper.AddSpaces("") // allows breaking if adding end bracket would exceed limit
-	wrapper.AddWord(">")
-
-	return wrapper.Column, nil
 }
 
-func printClosingTag(w io.Writer, n *html.Node, _ int, col uint) (colAfter uint, err error) {
-	colAfter = col + uint(2+len(n.Data))
-	_, err = fmt.Fprintf(w, "</%s>", n.Data)
+func (f *formatter) printClosingTag(w io.Writer, n *html.Node, _ int, col uint) (colAfter uint, err error) {
+	name := f.tagName(n)
+	colAfter = col + 2 + f.stringWidth(name)
+	_, err = fmt.Fprintf(w, "</%s>", name)
 	return
 }
 
-func passClosingTag(n *html.Node, wrapper *WordWrapper) (colAfter uint, err error) {
-	wrapper.AddWord("</" + n.Data + ">")
+func (f *formatter) passClosingTag(n *html.Node, wrapper *WordWrapper) (colAfter uint, err error) {
+	wrapper.AddWord("</" + f.tagName(n) + ">")
 	return wrapper.Column, nil
 }
 
-func printNewLine(w io.Writer, _ *html.Node, _ int, _ uint) (uint, error) {
-	_, err := fmt.Fprint(w, "\n")
+func (f *formatter) printNewLine(w io.Writer, _ *html.Node, _ int, _ uint) (uint, error) {
+	_, err := fmt.Fprint(w, f.lineEnding())
 	return uint(0), err
 }
 
-func printData(w io.Writer, n *html.Node, _ int, col uint) (colAfter uint, err error) {
-	s := getRenderedStringData(n)
-	colAfter = col + uint(utf8.RuneCountInString(s))
+func (f *formatter) printData(w io.Writer, n *html.Node, _ int, col uint) (colAfter uint, err error) {
+	s := escapedText(n)
+	if isRawPre(n.Parent, 0, 0) {
+		s = n.Data
+	}
+	colAfter = col + f.stringWidth(s)
 	_, err = fmt.Fprint(w, s)
 	return
 }
@@ -423,71 +2227,184 @@ func printIfElse(cf Conditional, printerIfTrue, printerIfFalse NodePrinter) Node
 	}
 }
 
-func printElementNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+func (f *formatter) printElementNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
 	switch {
-	case isPre(n, level, col):
+	case f.isPreformatted(n, level, col), isTextarea(n, level, col), hasPreWhiteSpaceStyle(n, level, col):
+		return f.printVerbatimElement(w, n, level, col)
+
+	case isIgnoredElement(n, level, col):
+		return f.printVerbatimElement(w, n, level, col)
+
+	case f.opts.PreserveUnknownElements && isUnknownElement(n, level, col):
+		return f.printVerbatimElement(w, n, level, col)
+
+	case f.isParagraphLike(n, level, col):
+		return f.printParagraphLikeNode(w, n, level, col)
+
+	case f.isVoidElement(n, level, col):
 		return runPrinters(
-			printIndent,
-			printOpeningTag,
-			printDelegateChildren(printPreChild),
-			printClosingTag,
-			printNewLine,
+			f.printIndent,
+			f.printOpeningTag,
+			f.printNewLine,
+			f.printChildren,
 		)(w, n, level, col)
 
-	case isParagraphLike(n, level, col):
-		return printParagraphLikeNode(w, n, level, col)
+	case isTemplateScript(n, level, col) && hasSingleTextChild(n, level, col):
+		return f.printTemplateScript(w, n, level, col)
 
-	case isEmptyElement(n, level, col):
+	case isScriptWithSrcAttribute(n, level, col):
 		return runPrinters(
-			printIndent,
-			printOpeningTag,
-			printNewLine,
+			f.printIndent,
+			f.printOpeningTag,
+			f.printClosingTag,
+			f.printNewLine,
 		)(w, n, level, col)
 
-	case isScriptWithSrcAttribute(n, level, col):
+	case isBlankElement(n, level, col) && !f.isSpecialContentElement(n, level, col):
 		return runPrinters(
-			printIndent,
-			printOpeningTag,
-			printClosingTag,
-			printNewLine,
+			f.printIndent,
+			f.printOpeningTag,
+			f.printClosingTag,
+			printIf(
+				anyIs(noNextSibling, nextSiblingIsNotPunctuation, nextSiblingIsElementNode),
+				f.printNewLine,
+			),
 		)(w, n, level, col)
 
+	case f.opts.CompactShortElements && f.isCompactable(n) && f.fitsOnOneLine(n, level, col):
+		return f.printCompactElement(w, n, level, col)
+
 	default:
 		return runPrinters(
-			printIndent,
-			printOpeningTag,
-			printIf(not(hasSingleTextChild), printNewLine),
+			f.printIndent,
+			f.printOpeningTag,
+			printIf(not(hasSingleNonEmptyTextChild), f.printNewLine),
 			printIfElse(
-				isHtmlElement, printChildren, incrementLevel(1, printChildren),
+				f.isUnindentedHtmlElement, f.printChildren, incrementLevel(1, f.printChildren),
 			),
 			printIf(
-				anyIs(isSpecialContentElement, not(hasSingleTextChild)),
-				printIndent,
+				anyIs(f.isSpecialContentElement, not(hasSingleNonEmptyTextChild)),
+				f.printIndent,
 			),
-			printClosingTag,
+			f.printClosingTag,
 			printIf(
 				anyIs(noNextSibling, nextSiblingIsNotPunctuation, nextSiblingIsElementNode),
-				printNewLine,
+				f.printNewLine,
 			),
 		)(w, n, level, col)
 	}
 }
 
-func printParagraphLikeNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+// Can the element be laid out like a paragraph? It must have children other
+// than a single text node and all of them must be text or inline elements.
+func (f *formatter) isCompactable(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Html, atom.Head, atom.Body:
+		return false
+	}
+
+	return n.FirstChild != nil && !hasSingleTextChild(n, 0, 0) && hasOnlyInlineContent(n)
+}
+
+func hasOnlyInlineContent(n *html.Node) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			if !isInlineElement(child, 0, 0) || isTextarea(child, 0, 0) || hasPreWhiteSpaceStyle(child, 0, 0) ||
+				isIgnoredElement(child, 0, 0) || !hasOnlyInlineContent(child) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Does the element fit on one line when printed like a paragraph? It is printed
+// to a buffer to find out, with the callbacks turned off so that they are only
+// called when it is printed for real.
+func (f *formatter) fitsOnOneLine(n *html.Node, level int, col uint) bool {
+	onWrap, warn := f.opts.OnWrap, f.opts.Warn
+	f.opts.OnWrap, f.opts.Warn = nil, nil
+	defer func() {
+		f.opts.OnWrap, f.opts.Warn = onWrap, warn
+	}()
+
+	var b bytes.Buffer
+	if _, err := f.printCompactElement(&b, n, level, col); err != nil {
+		return false
+	}
+
+	line, found := strings.CutSuffix(b.String(), f.lineEnding())
+	return found && !strings.Contains(line, f.lineEnding()) && col+f.stringWidth(line) <= f.maxWidth()
+}
+
+// Prints the element like a paragraph, with the whitespace at the edges of its
+// contents trimmed like it is for paragraphs.
+func (f *formatter) printCompactElement(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	compacting := f.compacting
+	f.compacting = n
+	defer func() {
+		f.compacting = compacting
+	}()
+
+	return f.printParagraphLikeNode(w, n, level, col)
+}
+
+// Template scripts hold markup as raw text so it is parsed and formatted like
+// the children of the script.
+func (f *formatter) printTemplateScript(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	nodes, err := parseFragment(strings.NewReader(n.FirstChild.Data))
+	if err != nil {
+		return col, err
+	}
+
+	return runPrinters(
+		f.printIndent,
+		f.printOpeningTag,
+		f.printNewLine,
+		incrementLevel(1, func(w io.Writer, _ *html.Node, level int, col uint) (colAfter uint, err error) {
+			colAfter = col
+			for _, node := range nodes {
+				if colAfter, err = f.printNode(w, node, level, colAfter); err != nil {
+					return
+				}
+			}
+			return
+		}),
+		f.printIndent,
+		f.printClosingTag,
+		f.printNewLine,
+	)(w, n, level, col)
+}
+
+func (f *formatter) printParagraphLikeNode(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
 	return runPrinters(
-		printIndent,
-		printOpeningTag,
-		paragraphElementContents,
-		printClosingTag,
-		printNewLine,
+		f.printIndent,
+		f.printOpeningTag,
+		f.paragraphElementContents,
+		f.printClosingTag,
+		f.printNewLine,
 	)(w, n, level, col)
 }
 
-func paragraphElementContents(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+func (f *formatter) paragraphElementContents(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+	if f.opts.AlwaysExpandParagraphs && hasContent(n, level, col) {
+		return runPrinters(
+			f.printNewLine,
+			incrementLevel(1, f.printParagraphChildren),
+			f.printNewLine,
+			f.printIndent,
+		)(w, n, level, col)
+	}
+
 	lw := NewLineOrPassWriter(w)
 	colPrep, err := runPrinters(
-		printNewLine,
-		incrementLevel(1, printParagraphChildren),
+		f.printNewLine,
+		incrementLevel(1, f.printParagraphChildren),
 		func(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
 			lw.Drain()
 			return col, err
@@ -502,24 +2419,33 @@ func paragraphElementContents(w io.Writer, n *html.Node, level int, col uint) (c
 			return lw.IsEndOfFirstLineReached()
 		},
 		runPrinters(
-			printNewLine,
-			printIndent,
+			f.printNewLine,
+			f.printIndent,
 		),
 	)(w, n, level, colPrep)
 }
 
-func printParagraphChildren(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+func (f *formatter) printParagraphChildren(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
 	child := n.FirstChild
 	colAfter = col
 
-	wrapper := NewWordWrapper(w, WrapOptions{
-		Limit:       paragraphLength,
-		StartsAt:    col,
-		Indentation: indentAtLevel(level),
+	f.setLevel(level)
+	wrapper := acquireWordWrapper(w, WrapOptions{
+		Limit:         f.maxWidth(),
+		StartsAt:      col,
+		Indentation:   f.indentAtLevel(level),
+		RuneWidth:     f.opts.RuneWidth,
+		GraphemeWidth: f.opts.GraphemeWidth,
+		OnWrap:        f.wrapReporter(n),
+		LineEnding:    f.opts.LineEnding,
 	})
+	defer releaseWordWrapper(wrapper)
 
 	for child != nil {
-		if colAfter, err = printParagraphNode(w, child, level, wrapper); err != nil {
+		if err = f.checkContext(); err != nil {
+			return
+		}
+		if colAfter, err = f.printParagraphNode(w, child, level, wrapper); err != nil {
 			return
 		}
 		child = child.NextSibling
@@ -530,18 +2456,29 @@ func printParagraphChildren(w io.Writer, n *html.Node, level int, col uint) (col
 	return
 }
 
-func printParagraphNode(w io.Writer, n *html.Node, level int, wrapper *WordWrapper) (colAfter uint, err error) {
+func (f *formatter) printParagraphNode(w io.Writer, n *html.Node, level int, wrapper *WordWrapper) (colAfter uint, err error) {
 	switch n.Type {
 	case html.TextNode:
-		return printParagraphTextNode(w, n, level, wrapper)
+		return f.printParagraphTextNode(w, n, level, wrapper)
 	case html.ElementNode:
-		return printParagraphElementNode(w, n, level, wrapper)
+		return f.printParagraphElementNode(w, n, level, wrapper)
 	case html.CommentNode:
-		return printCommentNode(w, n, level, wrapper.Column)
+		if f.isStrippedComment(n) {
+			return wrapper.Column, nil
+		}
+		// kept in place among the words so that the text around it is not
+		// moved, and as a block when it spans lines so that they are not
+		// reindented
+		comment := "<!--" + n.Data + "-->"
+		if strings.Contains(comment, "\n") {
+			wrapper.AddBlock(comment)
+			return wrapper.Column, nil
+		}
+		return wrapper.AddWord(comment), nil
 	case html.DoctypeNode:
-		return printDoctypeNode(w, n, level, wrapper.Column)
+		return f.printDoctypeNode(w, n, level, wrapper.Column)
 	case html.DocumentNode:
-		return printChildren(w, n, level, wrapper.Column)
+		return f.printChildren(w, n, level, wrapper.Column)
 	}
 
 	return
@@ -594,6 +2531,28 @@ func trimSpace(s string) string {
 	return s[start:stop]
 }
 
+// Replaces leading and trailing whitespace with a single space.
+func collapseEdgeSpace(s string) string {
+	start := nonSpaceLeftIndex(s)
+	stop := spaceIndexRight(start, s)
+	if start == stop {
+		if s == "" {
+			return ""
+		}
+		return " "
+	}
+
+	collapsed := s[start:stop]
+	if start > 0 {
+		collapsed = " " + collapsed
+	}
+	if stop < len(s) {
+		collapsed += " "
+	}
+
+	return collapsed
+}
+
 func trimSpaceLeft(s string) string {
 	start := nonSpaceLeftIndex(s)
 
@@ -605,10 +2564,10 @@ func trimSpaceRight(s string) string {
 	return s[:stop]
 }
 
-func printParagraphTextNode(_ io.Writer, n *html.Node, level int, wrapper *WordWrapper) (colAfter uint, err error) {
-	s := getRenderedStringData(n)
+func (f *formatter) printParagraphTextNode(_ io.Writer, n *html.Node, level int, wrapper *WordWrapper) (colAfter uint, err error) {
+	s := escapedText(n)
 	endChild := noNextSibling(n, level, colAfter)
-	childOfP := isChildOfParagraph(n, level, colAfter)
+	childOfP := f.isChildOfParagraph(n, level, colAfter) || (f.compacting != nil && n.Parent == f.compacting)
 
 	if childOfP {
 		if noPrevSibling(n, level, colAfter) {
@@ -621,10 +2580,7 @@ func printParagraphTextNode(_ io.Writer, n *html.Node, level int, wrapper *WordW
 	}
 
 	if s != "" {
-		FeedWordsForWrapping(s, func(unit WrapUnit) uint {
-			colAfter = wrapper.AddUnit(unit)
-			return colAfter
-		})
+		colAfter = f.feedText(s, wrapper)
 
 		if endChild {
 			colAfter = 0
@@ -636,42 +2592,154 @@ func printParagraphTextNode(_ io.Writer, n *html.Node, level int, wrapper *WordW
 	return
 }
 
+// Adds the words and spaces of s to the wrapper.
+func (f *formatter) feedText(s string, wrapper *WordWrapper) (colAfter uint) {
+	if f.opts.CollapseWhitespace {
+		s = collapseHTMLSpace(s)
+	}
+	sentenceEnded := false
+	lineStarted := false
+	FeedWordsForWrapping(s, func(unit WrapUnit) uint {
+		if unit.typ == Spaces && lineStarted {
+			// the wrapper indents the line itself so indentation from the
+			// source is dropped to keep formatting idempotent
+			lineStarted = false
+			return colAfter
+		}
+		if unit.typ == Spaces && sentenceEnded && f.opts.SentenceSpacing > 0 {
+			unit = SpaceUnit(strings.Repeat(" ", f.opts.SentenceSpacing))
+		}
+		sentenceEnded = unit.typ == Word && endsSentence(string(unit.value))
+		lineStarted = unit.typ == NewLine
+		colAfter = wrapper.AddUnit(unit)
+		return colAfter
+	})
+
+	return
+}
+
+// Does the word end with sentence-ending punctuation, ignoring closing quotes
+// and brackets?
+func endsSentence(word string) bool {
+	word = strings.TrimRight(word, `"')]`+"\u2019\u201d")
+	word = strings.TrimSuffix(word, "&#34;")
+	word = strings.TrimSuffix(word, "&#39;")
+	if abbreviations[strings.ToLower(strings.TrimLeft(word, `"'(`+"\u2018\u201c"))] {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(word)
+	switch r {
+	case '.', '!', '?':
+		return true
+	}
+
+	return false
+}
+
+// Common abbreviations whose periods do not end a sentence.
+var abbreviations = map[string]bool{
+	"mr.": true, "mrs.": true, "ms.": true, "dr.": true, "prof.": true,
+	"sr.": true, "jr.": true, "st.": true, "vs.": true, "e.g.": true,
+	"i.e.": true, "cf.": true, "no.": true, "fig.": true,
+}
+
 func isAtFirstColumn(_ *html.Node, _ int, col uint) bool {
 	return col == 0
 }
 
-func printParagraphElementNode(w io.Writer, n *html.Node, level int, wrapper *WordWrapper) (colAfter uint, err error) {
+func (f *formatter) printParagraphElementNode(w io.Writer, n *html.Node, level int, wrapper *WordWrapper) (colAfter uint, err error) {
 	switch {
 
 	case isBreakElement(n, level, wrapper.Column):
-		passOpeningTag(n, wrapper)
+		f.passOpeningTag(n, wrapper)
 		wrapper.AddGreedyNewLine()
 		return wrapper.Column, nil
 
-	case isEmptyElement(n, level, wrapper.Column):
-		passOpeningTag(n, wrapper)
+	case f.isVoidElement(n, level, wrapper.Column):
+		f.passOpeningTag(n, wrapper)
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if _, err = f.printParagraphNode(w, child, level, wrapper); err != nil {
+				return
+			}
+		}
+
 		return wrapper.Column, nil
 
+	case f.isPreformatted(n, level, wrapper.Column), isTextarea(n, level, wrapper.Column),
+		hasPreWhiteSpaceStyle(n, level, wrapper.Column), isIgnoredElement(n, level, wrapper.Column):
+		return f.passVerbatimElement(n, level, wrapper)
+
 	default:
-		passOpeningTag(n, wrapper)
+		f.passOpeningTag(n, wrapper)
 		child := n.FirstChild
 		for child != nil {
-			if colAfter, err = printParagraphNode(w, child, level, wrapper); err != nil {
+			if colAfter, err = f.printParagraphNode(w, child, level, wrapper); err != nil {
 				return
 			}
 			child = child.NextSibling
 		}
-		passClosingTag(n, wrapper)
+		f.passClosingTag(n, wrapper)
 
 		return wrapper.Column, nil
 	}
 }
 
-func printChildren(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
+// Adds an element whose contents are kept as is to the wrapper as a single
+// word. When its contents span several lines it is put on lines of its own so
+// that its line breaks are not indented with the rest of the paragraph.
+func (f *formatter) passVerbatimElement(n *html.Node, level int, wrapper *WordWrapper) (colAfter uint, err error) {
+	var b bytes.Buffer
+	_, err = runPrinters(
+		f.printOpeningTag,
+		printIf(hasLeadingContentWhitespace, f.printNewLine),
+		printDelegateChildren(f.printPreChild),
+		f.printClosingTag,
+	)(&b, n, level, wrapper.Column)
+	if err != nil {
+		return
+	}
+
+	if !strings.Contains(b.String(), "\n") {
+		return wrapper.AddWord(b.String()), nil
+	}
+
+	f.keepingTrailingWhitespace(func() {
+		wrapper.AddBlock(b.String())
+	})
+	return wrapper.Column, nil
+}
+
+func (f *formatter) printChildren(w io.Writer, n *html.Node, level int, col uint) (colAfter uint, err error) {
 	child := n.FirstChild
 	colAfter = col
 	for child != nil {
-		if colAfter, err = printNode(w, child, level, colAfter); err != nil {
+		if err = f.checkContext(); err != nil {
+			return
+		}
+
+		if isFormatDirective(child, formatOffDirective) {
+			if region := f.preservedRegion(siblingsFrom(child)); region != nil {
+				if colAfter, err = f.printPreservedRegion(w, region, level, colAfter); err != nil {
+					return
+				}
+				child = region[len(region)-1].NextSibling
+				continue
+			}
+		}
+
+		if last := f.inlineRunEnd(child); last != nil {
+			if colAfter, err = f.printInlineRun(w, child, last, level, colAfter); err != nil {
+				return
+			}
+			child = last.NextSibling
+			continue
+		}
+
+		if colAfter, err = f.printBlankLines(w, child.PrevSibling, child, child.NextSibling, colAfter); err != nil {
+			return
+		}
+
+		if colAfter, err = f.printNode(w, child, level, colAfter); err != nil {
 			return
 		}
 		child = child.NextSibling
@@ -679,11 +2747,114 @@ func printChildren(w io.Writer, n *html.Node, level int, col uint) (colAfter uin
 	return
 }
 
-func indentAtLevel(level int) string {
-	return strings.Repeat(indentString, level)
+// Prints the blank lines kept for whitespace between two block elements.
+func (f *formatter) printBlankLines(w io.Writer, prev, n, next *html.Node, col uint) (colAfter uint, err error) {
+	colAfter = col
+	for i := f.blankLines(prev, n, next); i > 0; i-- {
+		if colAfter, err = f.printNewLine(w, n, 0, colAfter); err != nil {
+			return
+		}
+	}
+
+	return
 }
 
-func printIndent(w io.Writer, _ *html.Node, level int, _ uint) (uint, error) {
-	_, err := fmt.Fprint(w, indentAtLevel(level))
-	return 0, err
+// Returns how many blank lines of this whitespace between two block elements
+// should be kept.
+func (f *formatter) blankLines(prev, n, next *html.Node) int {
+	if !f.opts.PreserveBlankLines || !isBlockElement(prev) || !isBlockElement(next) || !isEmptyTextNode(n, 0, 0) {
+		return 0
+	}
+
+	lines := strings.Count(n.Data, "\n") - 1
+	if lines > f.opts.MaxBlankLines {
+		return f.opts.MaxBlankLines
+	}
+
+	return lines
+}
+
+func isBlockElement(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode && !isInlineElement(n, 0, 0)
+}
+
+// Can this node be part of a run of siblings printed on the same line?
+func (f *formatter) isInlineRunNode(n *html.Node) bool {
+	return n.Type == html.TextNode && !f.isChildOfSpecialContentElement(n, 0, 0) ||
+		f.opts.InlineVoidElements && f.isVoidElement(n, 0, 0) ||
+		n.Type == html.ElementNode && f.inlineElements[strings.ToLower(n.Data)]
+}
+
+// Returns the last node of the inline run that starts at the given node, or
+// nil if the node does not start one. A run is made of sibling text nodes and
+// inline elements and is only worth printing inline if it has both text and
+// elements.
+func (f *formatter) inlineRunEnd(first *html.Node) (last *html.Node) {
+	if (!f.opts.InlineVoidElements && len(f.inlineElements) == 0) || (first.PrevSibling != nil && f.isInlineRunNode(first.PrevSibling)) {
+		return nil
+	}
+
+	hasText, hasElement := false, false
+	for n := first; n != nil && f.isInlineRunNode(n); n = n.NextSibling {
+		if n.Type == html.TextNode {
+			hasText = hasText || !isEmptyTextNode(n, 0, 0)
+		} else {
+			hasElement = true
+		}
+		last = n
+	}
+
+	if hasText && hasElement {
+		return last
+	}
+
+	return nil
+}
+
+// Prints a run of sibling nodes on wrapped lines like the contents of a
+// paragraph.
+func (f *formatter) printInlineRun(w io.Writer, first, last *html.Node, level int, col uint) (colAfter uint, err error) {
+	f.setLevel(level)
+	wrapper := acquireWordWrapper(w, WrapOptions{
+		Limit:         f.maxWidth(),
+		Indentation:   f.indentAtLevel(level),
+		RuneWidth:     f.opts.RuneWidth,
+		GraphemeWidth: f.opts.GraphemeWidth,
+		OnWrap:        f.wrapReporter(first.Parent),
+		LineEnding:    f.opts.LineEnding,
+	})
+	defer releaseWordWrapper(wrapper)
+
+	for n := first; ; n = n.NextSibling {
+		if n.Type == html.TextNode {
+			s := escapedText(n)
+			if n == first {
+				s = trimSpaceLeft(s)
+			}
+			if n == last {
+				s = trimSpaceRight(s)
+			}
+			f.feedText(s, wrapper)
+		} else if _, err = f.printParagraphNode(w, n, level, wrapper); err != nil {
+			return
+		}
+
+		if n == last {
+			break
+		}
+	}
+	wrapper.FinalFlush()
+
+	return f.printNewLine(w, first, level, col)
+}
+
+func (f *formatter) indentAtLevel(level int) string {
+	return strings.Repeat(f.opts.Indent, level)
+}
+
+func (f *formatter) printIndent(w io.Writer, _ *html.Node, level int, _ uint) (uint, error) {
+	f.setLevel(level)
+	indent := f.indentAtLevel(level)
+	_, err := fmt.Fprint(w, indent)
+	return uint(utf8.RuneCountInString(indent)), err
 }
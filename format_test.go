@@ -6,6 +6,9 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 func TestFragmentFormat(t *testing.T) {
@@ -17,8 +20,7 @@ func TestFragmentFormat(t *testing.T) {
 		{
 			name:  "missing closing tags are inserted",
 			input: `<li>`,
-			expected: `<li>
-</li>
+			expected: `<li></li>
 `,
 		},
 		{
@@ -28,6 +30,14 @@ func TestFragmentFormat(t *testing.T) {
   <li style="&amp;&amp;">A</li>
   <li>B</li>
 </ol>
+`,
+		},
+		{
+			name:  "escaped html attribute values are not escaped again",
+			input: `<ol> <li title="a &amp; b &amp;amp; c &lt;" data-url="?x=1&amp;y=2"> A </li> </ol>`,
+			expected: `<ol>
+  <li title="a &amp; b &amp;amp; c &lt;" data-url="?x=1&amp;y=2">A</li>
+</ol>
 `,
 		},
 		{
@@ -193,6 +203,342 @@ silk <span class="foo">bar</span></code></pre>
   <div>Hello</div>
 </noscript>` + "\n",
 		},
+		{
+			name: "template scripts are formatted as markup",
+			input: `<div><script type="text/x-template" id="item">
+<li class="item">
+<span>{{ name }}</span></li></script></div>`,
+			expected: `<div>
+  <script type="text/x-template" id="item">
+    <li class="item">
+      <span>{{ name }}</span>
+    </li>
+  </script>
+</div>
+`,
+		},
+		{
+			name:  "figure images stay on their own line and long captions wrap",
+			input: `<article><figure><img src="/images/chart.png" alt="A chart"><figcaption>Figure 1. Quarterly revenue grew steadily across all regions, with the strongest growth in the northern markets during the final quarter.</figcaption></figure></article>`,
+			expected: `<article>
+  <figure>
+    <img src="/images/chart.png" alt="A chart">
+    <figcaption>
+      Figure 1. Quarterly revenue grew steadily across all regions, with the strongest growth in the
+      northern markets during the final quarter.
+    </figcaption>
+  </figure>
+</article>
+`,
+		},
+		{
+			name:  "figure with a short caption before a picture",
+			input: `<figure><figcaption>The <em>original</em> photo, taken in 1921.</figcaption><picture><source srcset="a.webp" type="image/webp"><img src="a.jpg" alt="Street"></picture></figure>`,
+			expected: `<figure>
+  <figcaption>The <em>original</em> photo, taken in 1921.</figcaption>
+  <picture>
+    <source srcset="a.webp" type="image/webp">
+    <img src="a.jpg" alt="Street">
+  </picture>
+</figure>
+`,
+		},
+		{
+			name: "elements with only a comment keep the comment on its own line",
+			input: `<div>
+  <!-- todo -->
+</div><section><!-- a --><!-- b --></section>`,
+			expected: `<div>
+  <!-- todo -->
+</div>
+<section>
+  <!-- a -->
+  <!-- b -->
+</section>
+`,
+		},
+		{
+			name: "elements with only a comment are formatted the same way again",
+			input: `<div>
+  <!-- todo -->
+</div>
+`,
+			expected: `<div>
+  <!-- todo -->
+</div>
+`,
+		},
+		{
+			name: "empty and whitespace-only paragraphs are compact",
+			input: `<div><p></p><p>   </p><p>
+  </p></div>`,
+			expected: `<div>
+  <p></p>
+  <p></p>
+  <p></p>
+</div>
+`,
+		},
+		{
+			name:     "Textarea content is kept verbatim",
+			input:    "<div><textarea>\n  line one\n  line two\n</textarea></div>\n",
+			expected: "<div>\n  <textarea>\n  line one\n  line two\n</textarea>\n</div>\n",
+		},
+		{
+			name:     "Textarea without leading whitespace stays on one line",
+			input:    "<textarea>default text</textarea>\n",
+			expected: "<textarea>default text</textarea>\n",
+		},
+		{
+			name:  "Conditional comments are kept intact",
+			input: `<!--[if lt IE 9]><link rel="stylesheet" href="ie8.css"><![endif]-->` + "\n" + `<!--[if lt IE 9]>` + "\n" + `  <script src="html5shiv.js"></script>` + "\n" + `<![endif]-->`,
+			expected: `<!--[if lt IE 9]><link rel="stylesheet" href="ie8.css"><![endif]-->
+<!--[if lt IE 9]>
+  <script src="html5shiv.js"></script>
+<![endif]-->
+`,
+		},
+		{
+			name:     "Conditional comments stay in place within paragraphs",
+			input:    `<p>Hi <!--[if IE]> old  browser <![endif]--> there</p>`,
+			expected: "<p>Hi <!--[if IE]> old  browser <![endif]--> there</p>\n",
+		},
+		{
+			name:  "CDATA sections in SVG style and script elements are kept verbatim",
+			input: "<svg><style><![CDATA[.a > .b { fill: red; }]]></style><script><![CDATA[\n  if (a < b && c) {\n    go();\n  }\n]]></script></svg>",
+			expected: `<svg>
+  <style>
+    <![CDATA[.a > .b { fill: red; }]]>
+  </style>
+  <script>
+    <![CDATA[
+    if (a < b && c) {
+      go();
+    }
+    ]]>
+  </script>
+</svg>
+`,
+		},
+		{
+			name:  "JSON script content is pretty-printed",
+			input: `<script type="application/ld+json">{"@context":"https://schema.org","name":"A <b> & c","list":[1,{"x":null}]}</script>`,
+			expected: `<script type="application/ld+json">
+  {
+    "@context": "https://schema.org",
+    "name": "A <b> & c",
+    "list": [
+      1,
+      {
+        "x": null
+      }
+    ]
+  }
+</script>
+`,
+		},
+		{
+			name:  "JSON script content that does not parse is kept as is",
+			input: `<script type="application/json">{"broken":</script>`,
+			expected: `<script type="application/json">
+  {"broken":
+</script>
+`,
+		},
+		{
+			name:  "plain script content is not escaped",
+			input: `<script>if (a < b && c) { x = "y"; }</script>`,
+			expected: `<script>
+  if (a < b && c) { x = "y"; }
+</script>
+`,
+		},
+		{
+			name:  "elements styled to keep their whitespace are kept verbatim",
+			input: "<section><div style=\"white-space: pre\">Name    Value\n  one      1\n  two      2</div><p style=\"color: red; WHITE-SPACE:pre-wrap !important\">  a   b  </p></section>",
+			expected: "<section>\n" +
+				"  <div style=\"white-space: pre\">Name    Value\n  one      1\n  two      2</div>\n" +
+				"  <p style=\"color: red; WHITE-SPACE:pre-wrap !important\">  a   b  </p>\n" +
+				"</section>\n",
+		},
+		{
+			name:     "elements styled not to wrap are formatted as usual",
+			input:    "<div style=\"white-space: nowrap\">  a   b  </div>",
+			expected: "<div style=\"white-space: nowrap\">a   b</div>\n",
+		},
+		{
+			name:     "xmp content is kept raw",
+			input:    "<div><xmp>  <b>bold</b> & a < b</xmp></div>",
+			expected: "<div>\n  <xmp>  <b>bold</b> & a < b</xmp>\n</div>\n",
+		},
+		{
+			name:     "listing content is kept verbatim",
+			input:    "<listing>\n  x  &lt;y&gt;</listing>",
+			expected: "<listing>\n  x  &lt;y&gt;</listing>\n",
+		},
+		{
+			name:     "plaintext content is kept raw without an end tag",
+			input:    "<p>Intro</p><plaintext>  <p>not a paragraph</p>\n",
+			expected: "<p>Intro</p>\n<plaintext>  <p>not a paragraph</p>\n",
+		},
+		{
+			name:     "non-breaking spaces are kept as content",
+			input:    "<div>&nbsp;</div><div><span>x</span> &nbsp; <span>y</span></div>",
+			expected: "<div>\u00a0</div>\n<div>\n  <span>x</span>\n  \u00a0\n  <span>y</span>\n</div>\n",
+		},
+		{
+			name:  "inline elements stay on the line of closing punctuation after them",
+			input: `<p><a>x</a>" and <a>x</a>) and <a>x</a>…</p><div><a>x</a>” <a>y</a>😀</div>`,
+			expected: `<p><a>x</a>&#34; and <a>x</a>) and <a>x</a>…</p>
+<div>
+  <a>x</a>”
+  <a>y</a>😀
+</div>
+`,
+		},
+		{
+			name:  "punctuation after whitespace or a line break is indented",
+			input: `<div><a>x</a> . <pre>y</pre>, <!-- c -->; ok</div>`,
+			expected: `<div>
+  <a>x</a>
+  .
+  <pre>y</pre>
+  ,
+  <!-- c -->
+  ; ok
+</div>
+`,
+		},
+		{
+			name:  "edge whitespace of inline elements outside paragraphs is kept",
+			input: `<div><span> spaced </span></div><div><b>  bold</b><em>x` + "\n" + `</em></div>`,
+			expected: `<div>
+  <span> spaced </span>
+</div>
+<div>
+  <b> bold</b>
+  <em>x </em>
+</div>
+`,
+		},
+		{
+			name:  "template contents are indented inside the template",
+			input: `<template><li>Item</li></template><ul><template id="row"><li class="x">Item <b>b</b></li></template></ul>`,
+			expected: `<template>
+  <li>Item</li>
+</template>
+<ul>
+  <template id="row">
+    <li class="x">
+      Item
+      <b>b</b>
+    </li>
+  </template>
+</ul>
+`,
+		},
+		{
+			name:  "empty elements are printed on one line",
+			input: "<div></div><section><span></span><p></p><div class=\"x\"></div><div> \n </div></section>",
+			expected: `<div></div>
+<section>
+  <span></span>
+  <p></p>
+  <div class="x"></div>
+  <div></div>
+</section>
+`,
+		},
+		{
+			name:  "elements marked to be ignored are printed as is",
+			input: "<section><div data-formathtml=\"ignore\">  weird   spacing  </div><p data-formathtml=\"ignore\"><span>a</span>   <b>b</b>\n   <i>c</i></p><p>x</p></section>",
+			expected: `<section>
+  <div data-formathtml="ignore">  weird   spacing  </div>
+  <p data-formathtml="ignore"><span>a</span>   <b>b</b>
+   <i>c</i></p>
+  <p>x</p>
+</section>
+`,
+		},
+		{
+			name:  "raw less than signs and ampersands in text are escaped",
+			input: `<div>a < b & c > d</div><p>Tom & Jerry <3 "quotes"</p>`,
+			expected: `<div>a &lt; b &amp; c &gt; d</div>
+<p>Tom &amp; Jerry &lt;3 &#34;quotes&#34;</p>
+`,
+		},
+		{
+			name:  "the text of raw text elements is not escaped again",
+			input: `<xmp>a &lt; <b></xmp><iframe>x &amp; y</iframe>`,
+			expected: `<xmp>a &lt; <b></xmp>
+<iframe>x &amp; y</iframe>
+`,
+		},
+		{
+			name:  "pre started in a paragraph closes it and is kept as is",
+			input: `<p>before <pre>  kept  </pre> after</p>`,
+			expected: `<p>before</p>
+<pre>
+  kept  </pre>
+after
+<p></p>
+`,
+		},
+		{
+			name:  "preformatted elements in paragraph-like elements keep their lines",
+			input: "<div><figure><figcaption>before <pre>  kept\nthis   and  that\n  x</pre> after</figcaption></figure></div>",
+			expected: `<div>
+  <figure>
+    <figcaption>
+      before
+      <pre>
+  kept
+this   and  that
+  x</pre>
+      after
+    </figcaption>
+  </figure>
+</div>
+`,
+		},
+		{
+			name:  "multi-line verbatim elements stay on the line of text they touch",
+			input: "<p>word<span style=\"white-space:pre\">x\ny</span>s end</p><p>two <code data-formathtml=\"ignore\">a\n  b</code>, and</p>",
+			expected: `<p>
+  word<span style="white-space:pre">x
+y</span>s end
+</p>
+<p>
+  two
+  <code data-formathtml="ignore">a
+  b</code>, and
+</p>
+`,
+		},
+		{
+			name:  "textareas in paragraphs keep their lines",
+			input: "<p>a <textarea>x\n  y </textarea> b <span style=\"white-space: pre\">  s  p </span> c</p>",
+			expected: `<p>
+  a
+  <textarea>x
+  y </textarea>
+  b <span style="white-space: pre">  s  p </span> c
+</p>
+`,
+		},
+		{
+			name:  "comments in a paragraph stay among its words",
+			input: "<p>a <!-- c --> b</p><p>one<!-- two -->three <!--\n  four\n--> five</p>",
+			expected: `<p>a <!-- c --> b</p>
+<p>
+  one<!-- two -->three
+  <!--
+  four
+-->
+  five
+</p>
+`,
+		},
 	}
 
 	for _, test := range tests {
@@ -206,47 +552,1813 @@ silk <span class="foo">bar</span></code></pre>
 				t.Fatalf("failed to format: %v", err)
 			}
 			assert.Equal(t, test.expected, w.String())
+			assertIdempotent(t, w.String(), DefaultOptions())
 		})
 	}
 }
 
-func TestDocumentFormat(t *testing.T) {
+// Asserts that formatting already formatted output does not change it.
+func assertIdempotent(t *testing.T, formatted string, opts FormatOptions) {
+	t.Helper()
+	w := new(strings.Builder)
+	if err := FragmentWithOptions(w, strings.NewReader(formatted), opts); err != nil {
+		t.Fatalf("failed to format again: %v", err)
+	}
+	assert.Equal(t, formatted, w.String(), "formatting again changed the output")
+}
+
+func TestFragmentFormatWithOptions(t *testing.T) {
 	tests := []struct {
 		name     string
 		input    string
+		options  func(opts *FormatOptions)
 		expected string
 	}{
 		{
-			name: "respects doctype declarations",
-			input: `<!DOCTYPE html>
-<html><head><link rel="stylesheet" href="/style.css"></head><body><h1>Hello</h1></body></html>
+			name:    "unknown elements are reformatted by default",
+			input:   `<div><my-widget>  keep` + "\n" + `    this   spacing </my-widget></div>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<div>
+  <my-widget>keep
+    this   spacing</my-widget>
+</div>
 `,
-			expected: `<!DOCTYPE html>
-<html>
-<head>
-  <link rel="stylesheet" href="/style.css">
-</head>
-<body>
-  <h1>Hello</h1>
-</body>
-</html>
+		},
+		{
+			name:  "unknown elements are preserved as is when enabled",
+			input: `<div><my-widget>  keep` + "\n" + `    this   spacing <b> x </b></my-widget><p> trimmed </p></div>`,
+			options: func(opts *FormatOptions) {
+				opts.PreserveUnknownElements = true
+			},
+			expected: `<div>
+  <my-widget>  keep
+    this   spacing <b> x </b></my-widget>
+  <p>trimmed</p>
+</div>
 `,
 		},
-	}
+		{
+			name:  "accessibility attributes are printed first with accessibility hints",
+			input: `<img src="a.png" class="x" role="presentation" aria-hidden="true" alt="">`,
+			options: func(opts *FormatOptions) {
+				opts.AccessibilityHints = true
+			},
+			expected: `<img alt="" aria-hidden="true" role="presentation" src="a.png" class="x">
+`,
+		},
+		{
+			name:  "accessibility attributes are ordered in paragraphs too",
+			input: `<p>See <img src="a.png" alt="A"></p>`,
+			options: func(opts *FormatOptions) {
+				opts.AccessibilityHints = true
+			},
+			expected: `<p>See <img alt="A" src="a.png"></p>
+`,
+		},
+		{
+			name:    "tokenized attributes are left as is by default",
+			input:   `<link rel="  preload   stylesheet " href="/a.css">`,
+			options: func(opts *FormatOptions) {},
+			expected: `<link rel="  preload   stylesheet " href="/a.css">
+`,
+		},
+		{
+			name:  "tokenized attribute tokens are separated by single spaces",
+			input: `<link rel="  preload   stylesheet " href="/a.css">`,
+			options: func(opts *FormatOptions) {
+				opts.TokenizedAttributes = []string{"rel", "sandbox"}
+			},
+			expected: `<link rel="preload stylesheet" href="/a.css">
+`,
+		},
+		{
+			name:  "long tokenized attribute values are wrapped",
+			input: `<div><iframe src="/embed" sandbox="allow-forms allow-modals allow-popups allow-same-origin allow-scripts allow-top-navigation allow-downloads allow-presentation"></iframe></div>`,
+			options: func(opts *FormatOptions) {
+				opts.TokenizedAttributes = []string{"rel", "sandbox"}
+			},
+			expected: `<div>
+  <iframe src="/embed" sandbox="allow-forms allow-modals allow-popups allow-same-origin
+    allow-scripts allow-top-navigation allow-downloads allow-presentation"></iframe>
+</div>
+`,
+		},
+		{
+			name:  "long tokenized attribute values are wrapped in paragraphs",
+			input: `<p>Read the <a rel="noopener noreferrer nofollow external help license bookmark author" href="/a">guide to the many relations of links</a> first.</p>`,
+			options: func(opts *FormatOptions) {
+				opts.TokenizedAttributes = []string{"rel"}
+			},
+			expected: `<p>
+  Read the <a rel="noopener noreferrer nofollow external help license bookmark author" href="/a">guide
+  to the many relations of links</a> first.
+</p>
+`,
+		},
+		{
+			name:  "top level elements are separated by a blank line when enabled",
+			input: `<section><h1>One</h1></section>` + "\n" + `<section><h1>Two</h1></section><!-- end --><section>Three</section>`,
+			options: func(opts *FormatOptions) {
+				opts.SeparateTopLevelElements = true
+			},
+			expected: `<section>
+  <h1>One</h1>
+</section>
 
-	for _, test := range tests {
-		test := test
-		t.Run(test.name, func(t *testing.T) {
-			t.Parallel()
+<section>
+  <h1>Two</h1>
+</section>
+<!-- end -->
 
-			r := strings.NewReader(test.input)
-			w := new(strings.Builder)
-			if err := Document(w, r); err != nil {
-				t.Fatalf("failed to format: %v", err)
-			}
-			if diff := cmp.Diff(test.expected, w.String()); diff != "" {
-				t.Error(diff)
-			}
-		})
-	}
+<section>Three</section>
+`,
+		},
+		{
+			name:  "only top level block elements are separated and source blank lines are kept",
+			input: "<section>One</section><span>a</span><b>b</b>\n<div>Two</div>\n\n\n<div>Three</div>",
+			options: func(opts *FormatOptions) {
+				opts.SeparateTopLevelElements = true
+				opts.PreserveBlankLines = true
+				opts.MaxBlankLines = 2
+			},
+			expected: `<section>One</section>
+<span>a</span>
+<b>b</b>
+<div>Two</div>
+
+
+<div>Three</div>
+`,
+		},
+		{
+			name:  "top level elements are not separated when no blank lines are allowed",
+			input: `<section>One</section><section>Two</section>`,
+			options: func(opts *FormatOptions) {
+				opts.SeparateTopLevelElements = true
+				opts.MaxBlankLines = 0
+			},
+			expected: `<section>One</section>
+<section>Two</section>
+`,
+		},
+		{
+			name:  "nested elements are not separated by blank lines",
+			input: `<section><div>One</div><div>Two</div></section>`,
+			options: func(opts *FormatOptions) {
+				opts.SeparateTopLevelElements = true
+			},
+			expected: `<section>
+  <div>One</div>
+  <div>Two</div>
+</section>
+`,
+		},
+		{
+			name:  "paragraphs are measured one column per rune by default",
+			input: `<p>WWWWW WWWWW WWWWW</p>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 20
+			},
+			expected: `<p>WWWWW WWWWW WWWWW</p>
+`,
+		},
+		{
+			name:  "paragraphs are measured with a custom rune width",
+			input: `<p>WWWWW WWWWW WWWWW</p>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 20
+				opts.RuneWidth = func(r rune) uint {
+					if r == 'W' {
+						return 2
+					}
+					return 1
+				}
+			},
+			expected: `<p>
+  WWWWW
+  WWWWW
+  WWWWW
+</p>
+`,
+		},
+		{
+			name:    "button text is trimmed by default",
+			input:   `<div><button> Save </button></div>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<div>
+  <button>Save</button>
+</div>
+`,
+		},
+		{
+			name:  "button text whitespace is kept when preserving interactive whitespace",
+			input: `<div><button>  Save` + "\n" + `  </button><button>Cancel </button><label>	Name</label><div> Trimmed </div></div>`,
+			options: func(opts *FormatOptions) {
+				opts.PreserveInteractiveWhitespace = true
+			},
+			expected: `<div>
+  <button> Save </button>
+  <button>Cancel </button>
+  <label> Name</label>
+  <div>Trimmed</div>
+</div>
+`,
+		},
+		{
+			name:    "void elements between text are printed on their own line by default",
+			input:   `<div>Text <img src="x"> more text</div>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<div>
+  Text
+  <img src="x">
+  more text
+</div>
+`,
+		},
+		{
+			name:  "void elements between text stay inline when enabled",
+			input: `<div>Text <img src="x"> more text<div>Before<br>After <b>bold</b> <img src="y"></div><img src="z"></div>`,
+			options: func(opts *FormatOptions) {
+				opts.InlineVoidElements = true
+			},
+			expected: `<div>
+  Text <img src="x"> more text
+  <div>
+    Before<br>
+    After
+    <b>bold</b>
+    <img src="y">
+  </div>
+  <img src="z">
+</div>
+`,
+		},
+		{
+			name:    "sentence spacing is kept by default",
+			input:   `<p>One.  Two.   Three four. Five?  "Six!"  Mr. Seven</p>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<p>One.  Two.   Three four. Five?  &#34;Six!&#34;  Mr. Seven</p>
+`,
+		},
+		{
+			name:  "double sentence spacing",
+			input: `<p>One.  Two.   Three four. Five?  "Six!" Mr. Seven, e.g. this, and eight nine ten eleven twelve thirteen fourteen. Fifteen.</p>`,
+			options: func(opts *FormatOptions) {
+				opts.SentenceSpacing = 2
+			},
+			expected: `<p>
+  One.  Two.  Three four.  Five?  &#34;Six!&#34;  Mr. Seven, e.g. this, and eight nine ten eleven
+  twelve thirteen fourteen.  Fifteen.
+</p>
+`,
+		},
+		{
+			name:  "single sentence spacing",
+			input: `<p>One.  Two.   Three four. Five?  Six</p>`,
+			options: func(opts *FormatOptions) {
+				opts.SentenceSpacing = 1
+			},
+			expected: `<p>One. Two. Three four. Five? Six</p>
+`,
+		},
+		{
+			name:  "custom indentation",
+			input: `<ol><li><p>Lorem ipsum dolor sit amet, consectetur adipiscing elit. Cras in blandit odio, eget gravida eros.</p></li><li>B</li></ol>`,
+			options: func(opts *FormatOptions) {
+				opts.Indent = "\t"
+			},
+			expected: "<ol>\n\t<li>\n\t\t<p>Lorem ipsum dolor sit amet, consectetur adipiscing elit. Cras in blandit odio, eget gravida eros.</p>\n\t</li>\n\t<li>B</li>\n</ol>\n",
+		},
+		{
+			name:  "four space indentation",
+			input: `<ol><li><a href="#">A</a>.</li></ol>`,
+			options: func(opts *FormatOptions) {
+				opts.Indent = "    "
+			},
+			expected: `<ol>
+    <li>
+        <a href="#">A</a>.
+    </li>
+</ol>
+`,
+		},
+		{
+			name:  "empty indentation prints flat output",
+			input: `<ol><li><a href="#">A</a>.</li><li><p>Lorem ipsum dolor sit amet, consectetur adipiscing elit. Cras in blandit odio, eget gravida eros. In tincidunt.</p></li></ol>`,
+			options: func(opts *FormatOptions) {
+				opts.Indent = ""
+			},
+			expected: `<ol>
+<li>
+<a href="#">A</a>.
+</li>
+<li>
+<p>
+Lorem ipsum dolor sit amet, consectetur adipiscing elit. Cras in blandit odio, eget gravida eros. In
+tincidunt.
+</p>
+</li>
+</ol>
+`,
+		},
+		{
+			name:    "boolean attributes keep their values by default",
+			input:   `<input type="checkbox" checked disabled="disabled">`,
+			options: func(opts *FormatOptions) {},
+			expected: `<input type="checkbox" checked="" disabled="disabled">
+`,
+		},
+		{
+			name:  "boolean attributes are collapsed",
+			input: `<div><input type="checkbox" checked disabled="disabled" required="false"><p>Pick <input type="radio" selected=""></p></div>`,
+			options: func(opts *FormatOptions) {
+				opts.CollapseBooleanAttributes = true
+			},
+			expected: `<div>
+  <input type="checkbox" checked disabled required="false">
+  <p>Pick <input type="radio" selected></p>
+</div>
+`,
+		},
+		{
+			name:  "aria and enumerated attributes are never collapsed",
+			input: `<div aria-hidden="false" aria-busy="" contenteditable="" hidden="">x</div>`,
+			options: func(opts *FormatOptions) {
+				opts.CollapseBooleanAttributes = true
+				opts.BooleanAttributes = []string{"aria-hidden", "aria-busy", "contenteditable", "hidden"}
+			},
+			expected: `<div aria-hidden="false" aria-busy="" contenteditable="" hidden>x</div>
+`,
+		},
+		{
+			name:  "boolean attributes in the deny list are not collapsed",
+			input: `<video controls="" muted=""></video>`,
+			options: func(opts *FormatOptions) {
+				opts.CollapseBooleanAttributes = true
+				opts.NonBooleanAttributes = []string{"muted"}
+			},
+			expected: `<video controls muted=""></video>
+`,
+		},
+		{
+			name: "multiline attribute values are escaped by default",
+			input: `<section><div data-json='{
+        "a": 1
+      }'></div></section>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<section>
+  <div data-json="{
+        &#34;a&#34;: 1
+      }"></div>
+</section>
+`,
+		},
+		{
+			name: "multiline attribute values keep their relative indentation",
+			input: `<section><div class="x" data-json='{
+        "a": 1,
+        "b": [
+          "c &amp; d"
+        ]
+      }' id="y">Text</div></section>`,
+			options: func(opts *FormatOptions) {
+				opts.PreserveMultilineAttributes = true
+			},
+			expected: `<section>
+  <div class="x" data-json='{
+    "a": 1,
+    "b": [
+      "c &amp; d"
+    ]
+  }' id="y">Text</div>
+</section>
+`,
+		},
+		{
+			name:  "lines end with the configured line ending",
+			input: "<div><p>Some long paragraph text that has to wrap across a few lines here.</p><!-- note --><pre>a\nb</pre></div>",
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 40
+				opts.LineEnding = "\r\n"
+			},
+			expected: "<div>\r\n" +
+				"  <p>\r\n" +
+				"    Some long paragraph text that has to\r\n" +
+				"    wrap across a few lines here.\r\n" +
+				"  </p>\r\n" +
+				"  <!-- note -->\r\n" +
+				"  <pre>a\nb</pre>\r\n" +
+				"</div>\r\n",
+		},
+		{
+			name:  "long SVG transform attributes are kept on one line by default",
+			input: `<svg><g transform="translate(10, 20) rotate(45 5 5) scale(2) skewX(30)"></g></svg>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 50
+			},
+			expected: `<svg>
+  <g transform="translate(10, 20) rotate(45 5 5) scale(2) skewX(30)"></g>
+</svg>
+`,
+		},
+		{
+			name:  "long SVG transform attributes are wrapped between functions when enabled",
+			input: `<svg><g transform="translate(10, 20) rotate(45 5 5) scale(2) skewX(30)" style="fill: red; stroke: blue; stroke-width: 2px;"></g></svg>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 50
+				opts.WrapSVGAttributes = true
+			},
+			expected: `<svg>
+  <g transform="translate(10, 20) rotate(45 5 5)
+    scale(2) skewX(30)" style="fill: red;
+    stroke: blue; stroke-width: 2px;"></g>
+</svg>
+`,
+		},
+		{
+			name:  "plain text is reflowed when wrapping plain text",
+			input: "  The quick brown fox jumps over the lazy dog &amp; keeps\n running far away.\n",
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 30
+				opts.WrapPlainText = true
+			},
+			expected: `The quick brown fox jumps over
+the lazy dog &amp; keeps
+running far away.
+`,
+		},
+		{
+			name:  "input with tags is not treated as plain text",
+			input: "<b>bold</b> text",
+			options: func(opts *FormatOptions) {
+				opts.WrapPlainText = true
+			},
+			expected: "<b>bold</b>\ntext\n",
+		},
+		{
+			name:  "a standalone comment is stripped when stripping comments",
+			input: "<!-- only a comment -->\n",
+			options: func(opts *FormatOptions) {
+				opts.StripComments = true
+			},
+			expected: "",
+		},
+		{
+			name:  "comments between block elements are stripped without leaving blank lines",
+			input: "<div>\n  <p>a</p>\n  <!-- between -->\n  <p>b</p>\n</div>\n<p>Some <!-- inline --> text</p>",
+			options: func(opts *FormatOptions) {
+				opts.StripComments = true
+			},
+			expected: `<div>
+  <p>a</p>
+  <p>b</p>
+</div>
+<p>Some text</p>
+`,
+		},
+		{
+			name:  "conditional comments are kept when stripping comments",
+			input: `<div><!--[if lt IE 9]><link rel="stylesheet" href="ie8.css"><![endif]--><!-- dropped --></div>`,
+			options: func(opts *FormatOptions) {
+				opts.StripComments = true
+			},
+			expected: `<div>
+  <!--[if lt IE 9]><link rel="stylesheet" href="ie8.css"><![endif]-->
+</div>
+`,
+		},
+		{
+			name:  "attributes are sorted by name when sorting attributes",
+			input: `<input type="text" name="x" id="y" class="z"><p>Type <input type="text" name="x" id="y" class="z"> here</p>`,
+			options: func(opts *FormatOptions) {
+				opts.SortAttributes = true
+			},
+			expected: `<input class="z" id="y" name="x" type="text">
+<p>Type <input class="z" id="y" name="x" type="text"> here</p>
+`,
+		},
+		{
+			name:  "attribute values are printed in single quotes when asked for",
+			input: `<div title='Say "hi" it&#39;s me' class="a b"><p>A <abbr title='"quoted" &amp; it&#39;s'>Q</abbr> here</p></div>`,
+			options: func(opts *FormatOptions) {
+				opts.AttributeQuote = '\''
+			},
+			expected: `<div title='Say "hi" it&#39;s me' class='a b'>
+  <p>A <abbr title='"quoted" &amp; it&#39;s'>Q</abbr> here</p>
+</div>
+`,
+		},
+		{
+			name:    "attribute values with both kinds of quotes escape double quotes by default",
+			input:   `<div title='Say "hi" it&#39;s me'></div>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<div title="Say &#34;hi&#34; it&#39;s me"></div>
+`,
+		},
+		{
+			name:  "void elements are self-closed when asked for",
+			input: `<head><meta charset="utf-8"></head><div><img src="x"><p>One<br>two <img src="x"> three</p><br></div>`,
+			options: func(opts *FormatOptions) {
+				opts.SelfCloseVoidElements = true
+			},
+			expected: `<meta charset="utf-8" />
+<div>
+  <img src="x" />
+  <p>
+    One<br />
+    two <img src="x" /> three
+  </p>
+  <br />
+</div>
+`,
+		},
+		{
+			name:  "elements without blank lines between them stay together when preserving blank lines",
+			input: "<div>One</div>\n<div>Two</div>",
+			options: func(opts *FormatOptions) {
+				opts.PreserveBlankLines = true
+			},
+			expected: "<div>One</div>\n<div>Two</div>\n",
+		},
+		{
+			name:  "a blank line between elements is kept when preserving blank lines",
+			input: "<div>One</div>\n\n<div>Two</div>\n<section>\n  <div>Three</div>\n\n  <div>Four</div>\n</section>",
+			options: func(opts *FormatOptions) {
+				opts.PreserveBlankLines = true
+			},
+			expected: "<div>One</div>\n\n<div>Two</div>\n<section>\n  <div>Three</div>\n\n  <div>Four</div>\n</section>\n",
+		},
+		{
+			name:  "several blank lines between elements are kept as one when preserving blank lines",
+			input: "<div>One</div>\n\n\n\n<div>Two</div>",
+			options: func(opts *FormatOptions) {
+				opts.PreserveBlankLines = true
+			},
+			expected: "<div>One</div>\n\n<div>Two</div>\n",
+		},
+		{
+			name:  "no blank lines are kept when preserving blank lines with a cap of zero",
+			input: "<div>One</div>\n\n\n\n<div>Two</div>\n\n<div>Three</div>",
+			options: func(opts *FormatOptions) {
+				opts.PreserveBlankLines = true
+				opts.MaxBlankLines = 0
+			},
+			expected: "<div>One</div>\n<div>Two</div>\n<div>Three</div>\n",
+		},
+		{
+			name:  "one blank line is kept when preserving blank lines with a cap of one",
+			input: "<div>One</div>\n\n\n\n<div>Two</div>\n\n<div>Three</div>",
+			options: func(opts *FormatOptions) {
+				opts.PreserveBlankLines = true
+				opts.MaxBlankLines = 1
+			},
+			expected: "<div>One</div>\n\n<div>Two</div>\n\n<div>Three</div>\n",
+		},
+		{
+			name:  "up to two blank lines are kept when preserving blank lines with a cap of two",
+			input: "<div>One</div>\n\n\n\n<div>Two</div>\n\n<div>Three</div>\n<section>\n  <div>Four</div>\n\n\n  <div>Five</div>\n</section>",
+			options: func(opts *FormatOptions) {
+				opts.PreserveBlankLines = true
+				opts.MaxBlankLines = 2
+			},
+			expected: "<div>One</div>\n\n\n<div>Two</div>\n\n<div>Three</div>\n<section>\n  <div>Four</div>\n\n\n  <div>Five</div>\n</section>\n",
+		},
+		{
+			name:     "blank lines between elements are removed by default",
+			input:    "<div>One</div>\n\n<div>Two</div>",
+			options:  func(opts *FormatOptions) {},
+			expected: "<div>One</div>\n<div>Two</div>\n",
+		},
+		{
+			name:  "whitespace between elements is dropped even with non-breaking spaces when collapsing",
+			input: "<div> </div><div>&nbsp;</div><ul>\n <li>x</li>\n</ul><div><span>x</span> \t&nbsp; <span>y</span></div>",
+			options: func(opts *FormatOptions) {
+				opts.CollapseInterElementWhitespace = true
+			},
+			expected: "<div></div>\n<div>\u00a0</div>\n<ul>\n  <li>x</li>\n</ul>\n<div>\n  <span>x</span>\n  <span>y</span>\n</div>\n",
+		},
+		{
+			name:  "long words wrap at wbr elements",
+			input: `<p>Call getVeryLong<wbr>ConfigurationValue<wbr>FromTheServer<wbr>AndCache now.</p>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 30
+			},
+			expected: `<p>
+  Call getVeryLong<wbr
+  >ConfigurationValue<wbr
+  >FromTheServer<wbr>AndCache
+  now.
+</p>
+`,
+		},
+		{
+			name:     "final newline is added when the input has none",
+			input:    "<p>x</p>",
+			options:  func(opts *FormatOptions) {},
+			expected: "<p>x</p>\n",
+		},
+		{
+			name:     "final newline is kept when the input has one",
+			input:    "<p>x</p>\n",
+			options:  func(opts *FormatOptions) {},
+			expected: "<p>x</p>\n",
+		},
+		{
+			name:     "final newlines are reduced to one",
+			input:    "<div>x</div>\n\n\n<pre>y\n\n</pre>\n\n\ntext\n\n\n",
+			options:  func(opts *FormatOptions) {},
+			expected: "<div>x</div>\n<pre>y\n\n</pre>\ntext\n",
+		},
+		{
+			name:  "final newline can be left out",
+			input: "<div><p>x</p></div>\n\n",
+			options: func(opts *FormatOptions) {
+				opts.FinalNewline = false
+			},
+			expected: "<div>\n  <p>x</p>\n</div>",
+		},
+		{
+			name:  "final newline is left out of text fragments",
+			input: "text",
+			options: func(opts *FormatOptions) {
+				opts.FinalNewline = false
+			},
+			expected: "text",
+		},
+		{
+			name:  "final newline uses the line ending",
+			input: "<div><p>x</p></div>",
+			options: func(opts *FormatOptions) {
+				opts.LineEnding = "\r\n"
+			},
+			expected: "<div>\r\n  <p>x</p>\r\n</div>\r\n",
+		},
+		{
+			name:  "inline elements stay on the line of the text around them",
+			input: `<div>Run <code>go fmt</code> or <span>this one</span> now and <code>go vet</code> and more text to wrap it.</div>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 40
+				opts.InlineElements = []string{"code"}
+			},
+			expected: `<div>
+  Run <code>go fmt</code> or
+  <span>this one</span>
+  now and <code>go vet</code> and more
+  text to wrap it.
+</div>
+`,
+		},
+		{
+			name:  "default inline elements",
+			input: `<div>Posted <time datetime="2024-01-01">today</time> by the <abbr title="World Wide Web">WWW</abbr> team</div>`,
+			options: func(opts *FormatOptions) {
+				opts.InlineElements = DefaultInlineElements
+			},
+			expected: `<div>
+  Posted <time datetime="2024-01-01">today</time> by the <abbr title="World Wide Web">WWW</abbr> team
+</div>
+`,
+		},
+		{
+			name:  "attribute names are printed in lowercase",
+			input: `<div DATA-X="1"><svg viewBox="0 0 1 1" gradientTransform="none"></svg></div>`,
+			options: func(opts *FormatOptions) {
+				opts.LowercaseAttributes = true
+			},
+			expected: `<div data-x="1">
+  <svg viewBox="0 0 1 1" gradientTransform="none"></svg>
+</div>
+`,
+		},
+		{
+			name:  "repeated attributes are left out",
+			input: `<input type="a" type="b" name="x" TYPE="c"><p class="x" id="p" class="y">text <b title="1" title="2">bold</b> text</p>`,
+			options: func(opts *FormatOptions) {
+				opts.DeduplicateAttributes = true
+			},
+			expected: `<input type="a" name="x">
+<p class="x" id="p">text <b title="1">bold</b> text</p>
+`,
+		},
+		{
+			name:    "whitespace runs in text are kept by default",
+			input:   `<div>a    b</div>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<div>a    b</div>
+`,
+		},
+		{
+			name:  "whitespace runs in text are collapsed",
+			input: "<div>a    b\t\tc\n   d</div><div>x  y <b>y \t z</b>   z</div><p>a    b\n  c</p>",
+			options: func(opts *FormatOptions) {
+				opts.CollapseWhitespace = true
+			},
+			expected: `<div>a b c d</div>
+<div>
+  x y
+  <b>y z</b>
+  z
+</div>
+<p>a b c</p>
+`,
+		},
+		{
+			name:  "non-breaking spaces are kept when collapsing whitespace",
+			input: "<p>a  &nbsp; &nbsp;\tb</p><div>c&nbsp;&nbsp;  d</div>",
+			options: func(opts *FormatOptions) {
+				opts.CollapseWhitespace = true
+			},
+			expected: "<p>a \u00a0 \u00a0 b</p>\n<div>c\u00a0\u00a0 d</div>\n",
+		},
+		{
+			name:  "preformatted text, scripts and textareas are not collapsed",
+			input: "<pre>a    b</pre><script>a   =  1</script><textarea>a   b</textarea>",
+			options: func(opts *FormatOptions) {
+				opts.CollapseWhitespace = true
+			},
+			expected: `<pre>a    b</pre>
+<script>
+  a   =  1
+</script>
+<textarea>a   b</textarea>
+`,
+		},
+		{
+			name:  "indent size sets the number of spaces",
+			input: `<div><ul><li>One</li></ul><p>Text that wraps over the lines</p></div>`,
+			options: func(opts *FormatOptions) {
+				opts.IndentSize = 4
+				opts.MaxWidth = 20
+			},
+			expected: `<div>
+    <ul>
+        <li>One</li>
+    </ul>
+    <p>
+        Text that wraps over
+        the lines
+    </p>
+</div>
+`,
+		},
+		{
+			name:  "tabs take precedence over indent size",
+			input: `<div><ul><li>One</li></ul><p>Text that wraps over the lines</p></div>`,
+			options: func(opts *FormatOptions) {
+				opts.IndentSize = 4
+				opts.UseTabs = true
+				opts.MaxWidth = 20
+			},
+			expected: "<div>\n\t<ul>\n\t\t<li>One</li>\n\t</ul>\n\t<p>\n\t\tText that wraps over\n\t\tthe lines\n\t</p>\n</div>\n",
+		},
+		{
+			name:  "negative indent size uses two spaces",
+			input: `<div><p>x</p></div>`,
+			options: func(opts *FormatOptions) {
+				opts.Indent = "\t"
+				opts.IndentSize = -1
+			},
+			expected: `<div>
+  <p>x</p>
+</div>
+`,
+		},
+		{
+			name:  "attributes of long opening tags are put on their own lines when wrapping attributes",
+			input: `<section><div data-first-attribute="alpha value" data-second-attribute="beta value" data-third-attribute="gamma value" data-fourth-attribute="delta value" data-fifth-attribute="epsilon value" data-sixth-attribute="zeta value"><p>Text</p></div><div id="short">x</div></section>`,
+			options: func(opts *FormatOptions) {
+				opts.WrapAttributes = true
+			},
+			expected: `<section>
+  <div
+    data-first-attribute="alpha value"
+    data-second-attribute="beta value"
+    data-third-attribute="gamma value"
+    data-fourth-attribute="delta value"
+    data-fifth-attribute="epsilon value"
+    data-sixth-attribute="zeta value"
+  >
+    <p>Text</p>
+  </div>
+  <div id="short">x</div>
+</section>
+`,
+		},
+		{
+			name:  "wrapped opening tags of elements with text and void elements",
+			input: `<a href="https://example.com/a/long/path" class="link link-primary">Example</a><img src="image.png" alt="An image" width="640" height="480">`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 40
+				opts.WrapAttributes = true
+			},
+			expected: `<a
+  href="https://example.com/a/long/path"
+  class="link link-primary"
+>Example</a>
+<img
+  src="image.png"
+  alt="An image"
+  width="640"
+  height="480"
+>
+`,
+		},
+		{
+			name:  "full-width text is measured as one column per rune by default",
+			input: `<p>人間 人間 人間 人間</p>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 16
+			},
+			expected: `<p>人間 人間 人間 人間</p>
+`,
+		},
+		{
+			name:  "full-width text wraps earlier when measuring East Asian width",
+			input: `<p>人間 人間 人間 人間</p>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 16
+				opts.EastAsianWidth = true
+			},
+			expected: `<p>
+  人間 人間 人間
+  人間
+</p>
+`,
+		},
+		{
+			name:  "full-width words wrap at their visual width when measuring East Asian width",
+			input: `<div><p>日本語の文章です 日本語の文章です 日本語の文章です</p></div>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 40
+				opts.EastAsianWidth = true
+			},
+			expected: `<div>
+  <p>
+    日本語の文章です 日本語の文章です
+    日本語の文章です
+  </p>
+</div>
+`,
+		},
+		{
+			name:  "combining diacritics are counted by rune by default",
+			input: "<p>cafe\u0301 cafe\u0301 cafe\u0301 cafe\u0301</p>",
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 22
+			},
+			expected: "<p>\n  cafe\u0301 cafe\u0301 cafe\u0301\n  cafe\u0301\n</p>\n",
+		},
+		{
+			name:  "combining diacritics take up no columns when measuring graphemes",
+			input: "<p>cafe\u0301 cafe\u0301 cafe\u0301 cafe\u0301</p>",
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 22
+				opts.GraphemeWidth = true
+			},
+			expected: "<p>cafe\u0301 cafe\u0301 cafe\u0301 cafe\u0301</p>\n",
+		},
+		{
+			name:  "emoji sequences are two columns when measuring graphemes and East Asian width",
+			input: "<p>family \U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466 family \U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466</p>",
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 22
+				opts.EastAsianWidth = true
+				opts.GraphemeWidth = true
+			},
+			expected: "<p>family \U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466 family \U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466</p>\n",
+		},
+		{
+			name:  "short elements with inline content are kept on one line when compacting them",
+			input: "<ul><li><a href=\"/x\">Test</a>.</li><li>\n  <a href=\"/y\">Another</a>\n</li><li><div>Block</div></li><li><a href=\"/long\">A rather long link text that goes on</a> and then more text that makes the item go past the width.</li></ul>",
+			options: func(opts *FormatOptions) {
+				opts.CompactShortElements = true
+			},
+			expected: `<ul>
+  <li><a href="/x">Test</a>.</li>
+  <li><a href="/y">Another</a></li>
+  <li>
+    <div>Block</div>
+  </li>
+  <li>
+    <a href="/long">A rather long link text that goes on</a>
+    and then more text that makes the item go past the width.
+  </li>
+</ul>
+`,
+		},
+		{
+			name:    "short elements with inline content are expanded by default",
+			input:   `<ul><li><a href="/x">Test</a>.</li></ul>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<ul>
+  <li>
+    <a href="/x">Test</a>.
+  </li>
+</ul>
+`,
+		},
+		{
+			name:    "trailing whitespace in scripts is trimmed when enabled",
+			input:   "<div><script>\n  var a = 1;   \n  var b = 2;\t\n</script></div>",
+			options: func(opts *FormatOptions) { opts.TrimTrailingWhitespace = true },
+			expected: `<div>
+  <script>
+    var a = 1;
+    var b = 2;
+  </script>
+</div>
+`,
+		},
+		{
+			name:     "trailing whitespace is trimmed before windows line endings",
+			input:    "<style>\np { color: red; }  \n</style>",
+			options:  func(opts *FormatOptions) { opts.TrimTrailingWhitespace = true; opts.LineEnding = "\r\n" },
+			expected: "<style>\r\n  p { color: red; }\r\n</style>\r\n",
+		},
+		{
+			name:     "trailing whitespace in pre and textarea is kept when trimming",
+			input:    "<pre>a  \nb </pre><p>x <textarea>t  \nu</textarea> y</p>",
+			options:  func(opts *FormatOptions) { opts.TrimTrailingWhitespace = true },
+			expected: "<pre>a  \nb </pre>\n<p>\n  x\n  <textarea>t  \nu</textarea>\n  y\n</p>\n",
+		},
+		{
+			name:    "nested inline tags without attributes are not broken at the limit",
+			input:   `<p>aaaa bbbb cccc dddd <a><strong><em>eeee</em></strong></a> ffff</p>`,
+			options: func(opts *FormatOptions) { opts.MaxWidth = 30 },
+			expected: `<p>
+  aaaa bbbb cccc dddd
+  <a><strong><em>eeee</em></strong></a>
+  ffff
+</p>
+`,
+		},
+		{
+			name:    "nested inline tags stay together when the tag with attributes is broken",
+			input:   `<p>Lead in text <a href="/x"><strong><em>nested</em></strong></a> after it ends.</p>`,
+			options: func(opts *FormatOptions) { opts.MaxWidth = 30 },
+			expected: `<p>
+  Lead in text <a href="/x"
+  ><strong><em>nested</em></strong></a>
+  after it ends.
+</p>
+`,
+		},
+		{
+			name:    "closing tags of nested inline elements stay with the last word",
+			input:   `<p>aaaa bbbb cccc <a><strong><em>eeee ffff gggg hhhh iiii</em></strong></a> jj</p>`,
+			options: func(opts *FormatOptions) { opts.MaxWidth = 30 },
+			expected: `<p>
+  aaaa bbbb cccc
+  <a><strong><em>eeee ffff gggg
+  hhhh iiii</em></strong></a> jj
+</p>
+`,
+		},
+		{
+			name:    "short paragraphs are expanded when always expanding paragraphs",
+			input:   `<div><p>  Short <b>bold</b>  </p><p> </p><figcaption>Cap</figcaption></div>`,
+			options: func(opts *FormatOptions) { opts.AlwaysExpandParagraphs = true },
+			expected: `<div>
+  <p>
+    Short <b>bold</b>
+  </p>
+  <p></p>
+  <figcaption>
+    Cap
+  </figcaption>
+</div>
+`,
+		},
+		{
+			name:    "xhtml mode prints well-formed xml",
+			input:   `<DIV Class="x"><INPUT type=checkbox CHECKED disabled=""><BR><svg viewBox="0 0 1 1"><linearGradient/></svg><p>Hi<br>there <img src=a.png alt=""></p><div contenteditable></div></DIV>`,
+			options: func(opts *FormatOptions) { opts.XHTML = true; opts.CollapseBooleanAttributes = true },
+			expected: `<div class="x">
+  <input type="checkbox" checked="checked" disabled="disabled" />
+  <br />
+  <svg viewBox="0 0 1 1">
+    <linearGradient></linearGradient>
+  </svg>
+  <p>
+    Hi<br />
+    there <img src="a.png" alt="" />
+  </p>
+  <div contenteditable=""></div>
+</div>
+`,
+		},
+		{
+			name:    "tags with more attributes than the maximum per line are wrapped",
+			input:   `<div><input type="text" id="name" name="name" class="field" placeholder="Your name" required autocomplete="name" maxlength="40"><a href="/" class="x">Home</a></div>`,
+			options: func(opts *FormatOptions) { opts.MaxAttributesPerLine = 2 },
+			expected: `<div>
+  <input
+    type="text" id="name"
+    name="name" class="field"
+    placeholder="Your name" required=""
+    autocomplete="name" maxlength="40"
+  >
+  <a href="/" class="x">Home</a>
+</div>
+`,
+		},
+		{
+			name:    "tags in paragraphs are wrapped with the text regardless of the maximum attributes per line",
+			input:   `<p>Text <a href="/" class="x" id="y">link</a></p>`,
+			options: func(opts *FormatOptions) { opts.MaxAttributesPerLine = 2 },
+			expected: `<p>Text <a href="/" class="x" id="y">link</a></p>
+`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			r := strings.NewReader(test.input)
+			w := new(strings.Builder)
+
+			opts := DefaultOptions()
+			test.options(&opts)
+			if err := FragmentWithOptions(w, r, opts); err != nil {
+				t.Fatalf("failed to format: %v", err)
+			}
+			assert.Equal(t, test.expected, w.String())
+			assertIdempotent(t, w.String(), opts)
+		})
+	}
+}
+
+func TestFragmentFormatWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		options  func(opts *FormatOptions)
+		expected []string
+	}{
+		{
+			name:     "no warnings are reported for images without alt by default",
+			input:    `<div><img src="a.png"></div>`,
+			options:  func(opts *FormatOptions) {},
+			expected: nil,
+		},
+		{
+			name:  "images without alt are reported with accessibility hints",
+			input: `<div><img src="a.png"><img src="b.png" alt="B"><p>An <img src="c.png"></p></div>`,
+			options: func(opts *FormatOptions) {
+				opts.AccessibilityHints = true
+			},
+			expected: []string{
+				`<img src="a.png"/>: <img> is missing an alt attribute`,
+				`<img src="c.png"/>: <img> is missing an alt attribute`,
+			},
+		},
+		{
+			name:     "unbalanced template actions are not checked by default",
+			input:    `<div>{{ if .Foo }}<p>Foo</p></div>`,
+			options:  func(opts *FormatOptions) {},
+			expected: nil,
+		},
+		{
+			name:  "balanced template actions are not reported",
+			input: `<ul>{{ range .Items }}<li class="{{ if .Active }}active{{ end }}">{{ .Name }}</li>{{- end }}</ul>`,
+			options: func(opts *FormatOptions) {
+				opts.CheckTemplateDirectives = true
+			},
+			expected: nil,
+		},
+		{
+			name:  "template actions with no end are reported",
+			input: `<div>{{ if .Foo }}<p>Foo</p>{{ with .Bar }}{{ . }}{{ end }}</div>`,
+			options: func(opts *FormatOptions) {
+				opts.CheckTemplateDirectives = true
+			},
+			expected: []string{
+				`{{ if .Foo }}: {{ if }} has no matching {{ end }}`,
+			},
+		},
+		{
+			name:  "stray template ends are reported",
+			input: `<div>Foo</div>{{ end }}`,
+			options: func(opts *FormatOptions) {
+				opts.CheckTemplateDirectives = true
+			},
+			expected: []string{
+				`{{ end }}: {{ end }} has no matching opening action`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			r := strings.NewReader(test.input)
+			w := new(strings.Builder)
+
+			var warnings []string
+			opts := DefaultOptions()
+			test.options(&opts)
+			opts.Warn = func(n *html.Node, message string) {
+				if n != nil {
+					var b strings.Builder
+					require.NoError(t, html.Render(&b, n))
+					message = b.String() + ": " + message
+				}
+				warnings = append(warnings, message)
+			}
+			if err := FragmentWithOptions(w, r, opts); err != nil {
+				t.Fatalf("failed to format: %v", err)
+			}
+			assert.Equal(t, test.expected, warnings)
+		})
+	}
+}
+
+type countingWriter struct {
+	writes int
+	w      strings.Builder
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.w.Write(p)
+}
+
+func TestFragmentBuffersOutput(t *testing.T) {
+	input := `<ol> <li class="name"> A </li> <li> B </li> </ol><p>Some <b>text</b></p>`
+
+	unbuffered := &countingWriter{}
+	opts := DefaultOptions()
+	opts.BufferSize = -1
+	err := FragmentWithOptions(unbuffered, strings.NewReader(input), opts)
+	assert.NoError(t, err)
+
+	buffered := &countingWriter{}
+	err = FragmentWithOptions(buffered, strings.NewReader(input), DefaultOptions())
+	assert.NoError(t, err)
+
+	assert.Equal(t, unbuffered.w.String(), buffered.w.String())
+	assert.Equal(t, 1, buffered.writes)
+	assert.Greater(t, unbuffered.writes, buffered.writes)
+}
+
+func BenchmarkFragmentWrites(b *testing.B) {
+	input := strings.Repeat(`<div><ul><li><a href="#">Item</a>.</li><li>Other</li></ul><p>Lorem ipsum dolor sit amet, consectetur adipiscing elit.</p></div>`, 100)
+	benchmarks := []struct {
+		name       string
+		bufferSize int
+	}{
+		{name: "unbuffered", bufferSize: -1},
+		{name: "buffered", bufferSize: 0},
+	}
+
+	for _, bm := range benchmarks {
+		bm := bm
+		b.Run(bm.name, func(b *testing.B) {
+			writes := 0
+			for i := 0; i < b.N; i++ {
+				w := &countingWriter{}
+				opts := DefaultOptions()
+				opts.BufferSize = bm.bufferSize
+				if err := FragmentWithOptions(w, strings.NewReader(input), opts); err != nil {
+					b.Fatal(err)
+				}
+				writes += w.writes
+			}
+			b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestDocumentFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "respects doctype declarations",
+			input: `<!DOCTYPE html>
+<html><head><link rel="stylesheet" href="/style.css"></head><body><h1>Hello</h1></body></html>
+`,
+			expected: `<!DOCTYPE html>
+<html>
+<head>
+  <link rel="stylesheet" href="/style.css">
+</head>
+<body>
+  <h1>Hello</h1>
+</body>
+</html>
+`,
+		},
+		{
+			name:  "noscript in the head is formatted as elements",
+			input: `<!DOCTYPE html><html><head><title>Page</title><noscript><link rel="stylesheet" href="/noscript.css"><style>.js-only { display: none }</style></noscript></head><body><noscript><p>Enable JavaScript</p></noscript></body></html>`,
+			expected: `<!DOCTYPE html>
+<html>
+<head>
+  <title>Page</title>
+  <noscript>
+    <link rel="stylesheet" href="/noscript.css">
+    <style>
+      .js-only { display: none }
+    </style>
+  </noscript>
+</head>
+<body>
+  <noscript>
+    <p>Enable JavaScript</p>
+  </noscript>
+</body>
+</html>
+`,
+		},
+		{
+			name:  "no doctype is added to documents without one",
+			input: `<title>Page</title><p>Hi</p>`,
+			expected: `<html>
+<head>
+  <title>Page</title>
+</head>
+<body>
+  <p>Hi</p>
+</body>
+</html>
+`,
+		},
+		{
+			name:  "implied head of documents without a doctype is printed empty",
+			input: `<html lang="en"><body><p>Hi</p></body></html>`,
+			expected: `<html lang="en">
+<head></head>
+<body>
+  <p>Hi</p>
+</body>
+</html>
+`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := strings.NewReader(test.input)
+			w := new(strings.Builder)
+			if err := Document(w, r); err != nil {
+				t.Fatalf("failed to format: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, w.String()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestDocumentFormatWithOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		options  func(opts *FormatOptions)
+		expected string
+	}{
+		{
+			name:    "legacy doctype identifiers are kept as is by default",
+			input:   `<!DOCTYPE HTML PUBLIC   "-//W3C//DTD   HTML 4.01//EN"    "http://www.w3.org/TR/html4/strict.dtd"><title>x</title>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<!DOCTYPE html PUBLIC "-//W3C//DTD   HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:  "legacy doctype identifiers are canonicalized",
+			input: `<!DOCTYPE HTML PUBLIC   "-//W3C//DTD   HTML 4.01//EN"    "http://www.w3.org/TR/html4/strict.dtd"><title>x</title>`,
+			options: func(opts *FormatOptions) {
+				opts.CanonicalLegacyDoctype = true
+			},
+			expected: `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:  "unknown doctypes are left alone when canonicalizing",
+			input: `<!DOCTYPE html PUBLIC "-//Acme//DTD   Widgets//EN"><title>x</title>`,
+			options: func(opts *FormatOptions) {
+				opts.CanonicalLegacyDoctype = true
+			},
+			expected: `<!DOCTYPE html PUBLIC "-//Acme//DTD   Widgets//EN">
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:    "html children are not indented by default",
+			input:   `<!DOCTYPE html><title>x</title><p>y</p>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<!DOCTYPE html>
+<html>
+<head>
+  <title>x</title>
+</head>
+<body>
+  <p>y</p>
+</body>
+</html>
+`,
+		},
+		{
+			name:  "html children are indented when enabled",
+			input: `<!DOCTYPE html><title>x</title><p>y</p>`,
+			options: func(opts *FormatOptions) {
+				opts.IndentHtmlChildren = true
+			},
+			expected: `<!DOCTYPE html>
+<html>
+  <head>
+    <title>x</title>
+  </head>
+  <body>
+    <p>y</p>
+  </body>
+</html>
+`,
+		},
+		{
+			name:    "doctype is printed as is by default",
+			input:   `<!doctype html><title>x</title>`,
+			options: func(opts *FormatOptions) {},
+			expected: `<!DOCTYPE html>
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:  "doctype in uppercase",
+			input: `<!doctype html><title>x</title>`,
+			options: func(opts *FormatOptions) {
+				opts.DoctypeCase = DoctypeUpper
+			},
+			expected: `<!DOCTYPE HTML>
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:  "doctype in lowercase",
+			input: `<!DOCTYPE html><title>x</title>`,
+			options: func(opts *FormatOptions) {
+				opts.DoctypeCase = DoctypeLower
+			},
+			expected: `<!doctype html>
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:  "doctype with a public identifier is printed as is",
+			input: `<!doctype html public "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd"><title>x</title>`,
+			options: func(opts *FormatOptions) {
+				opts.DoctypeCase = DoctypeAsIs
+			},
+			expected: `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:  "doctype with a public identifier in uppercase",
+			input: `<!doctype html public "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd"><title>x</title>`,
+			options: func(opts *FormatOptions) {
+				opts.DoctypeCase = DoctypeUpper
+			},
+			expected: `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:  "doctype with a public identifier in lowercase",
+			input: `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd"><title>x</title>`,
+			options: func(opts *FormatOptions) {
+				opts.DoctypeCase = DoctypeLower
+			},
+			expected: `<!doctype html public "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:  "doctype with a system identifier in lowercase",
+			input: `<!DOCTYPE html SYSTEM "about:legacy-compat"><title>x</title>`,
+			options: func(opts *FormatOptions) {
+				opts.DoctypeCase = DoctypeLower
+			},
+			expected: `<!doctype html system "about:legacy-compat">
+<html>
+<head>
+  <title>x</title>
+</head>
+<body></body>
+</html>
+`,
+		},
+		{
+			name:    "xhtml mode keeps the xmlns attribute without adding one",
+			input:   `<!DOCTYPE html><html xmlns="http://www.w3.org/1999/xhtml" lang="en"><head><META charset="utf-8"><title>T</title></head><body><hr></body></html>`,
+			options: func(opts *FormatOptions) { opts.XHTML = true },
+			expected: `<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" lang="en">
+<head>
+  <meta charset="utf-8" />
+  <title>T</title>
+</head>
+<body>
+  <hr />
+</body>
+</html>
+`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := strings.NewReader(test.input)
+			w := new(strings.Builder)
+			opts := DefaultOptions()
+			test.options(&opts)
+			if err := DocumentWithOptions(w, r, opts); err != nil {
+				t.Fatalf("failed to format: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, w.String()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestFragmentFormatWrapReports(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		options  func(opts *FormatOptions)
+		expected []string
+	}{
+		{
+			name:     "short paragraphs are not reported",
+			input:    `<p>Lorem ipsum dolor sit amet.</p>`,
+			options:  func(opts *FormatOptions) {},
+			expected: nil,
+		},
+		{
+			name:    "overlong paragraphs are reported",
+			input:   `<div><p>Lorem ipsum dolor sit amet, consectetur adipiscing elit. Cras in blandit odio, eget gravida eros. In tincidunt, dolor nec blandit elementum.</p></div>`,
+			options: func(opts *FormatOptions) {},
+			expected: []string{
+				"p: Lorem ipsum dolor sit amet, consectetur adipiscing elit. Cras in blandit odio, eget gravida eros. In",
+			},
+		},
+		{
+			name:  "wrapped tokenized attributes are reported",
+			input: `<iframe sandbox="allow-forms allow-modals allow-popups allow-same-origin allow-scripts"></iframe>`,
+			options: func(opts *FormatOptions) {
+				opts.MaxWidth = 60
+				opts.TokenizedAttributes = []string{"sandbox"}
+			},
+			expected: []string{
+				"iframe: allow-forms allow-modals allow-popups",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			r := strings.NewReader(test.input)
+			w := new(strings.Builder)
+
+			var reports []string
+			opts := DefaultOptions()
+			test.options(&opts)
+			opts.OnWrap = func(n *html.Node, line string) {
+				reports = append(reports, n.Data+": "+line)
+			}
+			if err := FragmentWithOptions(w, r, opts); err != nil {
+				t.Fatalf("failed to format: %v", err)
+			}
+			assert.Equal(t, test.expected, reports)
+		})
+	}
+}
+
+func TestFragmentWrapAtWbrKeepsText(t *testing.T) {
+	input := `<p>Call getVeryLong<wbr>ConfigurationValue<wbr>FromTheServer<wbr>AndCache now.</p>`
+	opts := DefaultOptions()
+	opts.MaxWidth = 30
+
+	var b strings.Builder
+	require.NoError(t, FragmentWithOptions(&b, strings.NewReader(input), opts))
+	require.Contains(t, b.String(), "<wbr\n")
+	assert.Equal(t, collapseHTMLSpace(textOf(t, input)), collapseHTMLSpace(textOf(t, b.String())))
+}
+
+// Returns the text of the parsed fragment.
+func textOf(t *testing.T, s string) string {
+	nodes, err := parseFragment(strings.NewReader(s))
+	require.NoError(t, err)
+
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+func TestFragmentStripIgnoreAttribute(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StripIgnoreAttribute = true
+
+	var b strings.Builder
+	input := `<div class="widget" data-formathtml="ignore">  weird   spacing  </div>`
+	require.NoError(t, FragmentWithOptions(&b, strings.NewReader(input), opts))
+	assert.Equal(t, "<div class=\"widget\">  weird   spacing  </div>\n", b.String())
+
+	b.Reset()
+	input = `<svg data-formathtml="ignore" xmlns:xlink="http://www.w3.org/1999/xlink" xml:lang="en"><use xlink:href="#a"/></svg>`
+	require.NoError(t, FragmentWithOptions(&b, strings.NewReader(input), opts))
+	assert.Equal(t, "<svg xlink=\"http://www.w3.org/1999/xlink\" lang=\"en\"><use href=\"#a\"></use></svg>\n", b.String(), "namespaced attributes are kept")
+}
+
+func TestDefaultOptions(t *testing.T) {
+	opts := DefaultOptions()
+	assert.Equal(t, "  ", opts.Indent)
+	assert.Equal(t, uint(100), opts.MaxWidth)
+	assert.Equal(t, "", opts.LineEnding)
+	assert.False(t, opts.StripComments)
+
+	input := `<div><!-- note --><p>A paragraph that is long enough to be wrapped at the default width of one hundred columns.</p></div>`
+	var fragment, withOptions strings.Builder
+	require.NoError(t, Fragment(&fragment, strings.NewReader(input)))
+	require.NoError(t, FragmentWithOptions(&withOptions, strings.NewReader(input), opts))
+	assert.Equal(t, fragment.String(), withOptions.String())
+}
+
+func TestFormatString(t *testing.T) {
+	actual, err := FormatString(`<ol> <li class="name"> A </li> <li> B </li> </ol> `)
+	assert.NoError(t, err)
+	assert.Equal(t, `<ol>
+  <li class="name">A</li>
+  <li>B</li>
+</ol>
+`, actual)
+}
+
+func TestFormatBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:  "only the children of the body are formatted",
+			input: `<!DOCTYPE html><html><head><title>Page</title></head><body><h1>Hello</h1><ul><li>One</li><li>Two</li></ul></body></html>`,
+			expected: `<h1>Hello</h1>
+<ul>
+  <li>One</li>
+  <li>Two</li>
+</ul>
+`,
+		},
+		{
+			name:  "content without body tags is formatted",
+			input: `<title>Page</title><p>Text</p>`,
+			expected: `<p>Text</p>
+`,
+		},
+		{
+			name:  "documents without a body are formatted whole",
+			input: `<!DOCTYPE html><html><frameset cols="50%,50%"></frameset></html>`,
+			expected: `<!DOCTYPE html>
+<html>
+<head></head>
+<frameset cols="50%,50%"></frameset>
+</html>
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, FormatBody(&b, strings.NewReader(test.input), DefaultOptions()))
+			assert.Equal(t, test.expected, b.String())
+		})
+	}
+}
+
+func TestFormatDocumentString(t *testing.T) {
+	actual, err := FormatDocumentString(`<!DOCTYPE html><title>Hi</title><h1>Hello</h1>`)
+	assert.NoError(t, err)
+	assert.Equal(t, `<!DOCTYPE html>
+<html>
+<head>
+  <title>Hi</title>
+</head>
+<body>
+  <h1>Hello</h1>
+</body>
+</html>
+`, actual)
+}
+
+func TestNodesLowercaseTags(t *testing.T) {
+	div := &html.Node{Type: html.ElementNode, Data: "DIV", DataAtom: atom.Div}
+	a := &html.Node{Type: html.ElementNode, Data: "A", DataAtom: atom.A, Attr: []html.Attribute{{Key: "href", Val: "/"}}}
+	a.AppendChild(&html.Node{Type: html.TextNode, Data: "Home"})
+	div.AppendChild(a)
+	svg := &html.Node{Type: html.ElementNode, Data: "svg", DataAtom: atom.Svg, Namespace: "svg"}
+	svg.AppendChild(&html.Node{Type: html.ElementNode, Data: "linearGradient", Namespace: "svg"})
+	div.AppendChild(svg)
+	// charset is only known as an attribute name, so this is an unknown tag
+	div.AppendChild(&html.Node{Type: html.ElementNode, Data: "CHARSET"})
+
+	opts := DefaultOptions()
+	opts.LowercaseTags = true
+	var b strings.Builder
+	assert.NoError(t, NodesWithOptions(&b, []*html.Node{div}, opts))
+	assert.Equal(t, `<div>
+  <a href="/">Home</a>
+  <svg>
+    <linearGradient></linearGradient>
+  </svg>
+  <CHARSET></CHARSET>
+</div>
+`, b.String())
+}
+
+func TestNodesLowercaseAttributes(t *testing.T) {
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div, Attr: []html.Attribute{{Key: "DATA-X", Val: "1"}}}
+	svg := &html.Node{Type: html.ElementNode, Data: "svg", DataAtom: atom.Svg, Namespace: "svg", Attr: []html.Attribute{
+		{Key: "viewBox", Val: "0 0 1 1"},
+		{Key: "preserveAspectRatio", Val: "none"},
+		{Key: "Fill", Val: "red"},
+	}}
+	div.AppendChild(svg)
+
+	opts := DefaultOptions()
+	opts.LowercaseAttributes = true
+	var b strings.Builder
+	assert.NoError(t, NodesWithOptions(&b, []*html.Node{div}, opts))
+	assert.Equal(t, `<div data-x="1">
+  <svg viewBox="0 0 1 1" preserveAspectRatio="none" fill="red"></svg>
+</div>
+`, b.String())
+}
+
+func TestNodesAtLevel(t *testing.T) {
+	nodes, err := parseFragment(strings.NewReader(`<ul><li>One</li><li><p>Two has text that is long enough to be wrapped <b>over</b> more than one line at the default width of the formatter.</p></li></ul>`))
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, NodesAtLevel(&b, nodes, 2))
+	assert.Equal(t, `    <ul>
+      <li>One</li>
+      <li>
+        <p>
+          Two has text that is long enough to be wrapped <b>over</b> more than one line at the default width
+          of the formatter.
+        </p>
+      </li>
+    </ul>
+`, b.String())
+
+	b.Reset()
+	assert.ErrorIs(t, NodesAtLevel(&b, nodes, -1), ErrNegativeLevel)
+	assert.Empty(t, b.String())
+}
+
+func TestNode(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<!DOCTYPE html><title>T</title><p>Hi</p>`))
+	require.NoError(t, err)
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	div.AppendChild(&html.Node{Type: html.ElementNode, Data: "br", DataAtom: atom.Br})
+
+	tests := []struct {
+		name     string
+		node     *html.Node
+		expected string
+	}{
+		{
+			name:     "element",
+			node:     div,
+			expected: "<div>\n  <br>\n</div>\n",
+		},
+		{
+			name:     "text",
+			node:     &html.Node{Type: html.TextNode, Data: "a & b"},
+			expected: "a &amp; b\n",
+		},
+		{
+			name:     "comment",
+			node:     &html.Node{Type: html.CommentNode, Data: " note "},
+			expected: "<!-- note -->\n",
+		},
+		{
+			name:     "doctype",
+			node:     doc.FirstChild,
+			expected: "<!DOCTYPE html>\n",
+		},
+		{
+			name: "document",
+			node: doc,
+			expected: `<!DOCTYPE html>
+<html>
+<head>
+  <title>T</title>
+</head>
+<body>
+  <p>Hi</p>
+</body>
+</html>
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, Node(&b, test.node))
+			assert.Equal(t, test.expected, b.String())
+		})
+	}
+}
+
+func TestFragmentN(t *testing.T) {
+	w := new(strings.Builder)
+	n, err := FragmentN(w, strings.NewReader(`<ul><li>A</li><li>Ü</li></ul>`))
+	assert.NoError(t, err)
+	assert.Equal(t, "<ul>\n  <li>A</li>\n  <li>Ü</li>\n</ul>\n", w.String())
+	assert.Equal(t, int64(w.Len()), n)
+}
+
+func TestDocumentN(t *testing.T) {
+	w := new(strings.Builder)
+	n, err := DocumentN(w, strings.NewReader(`<!DOCTYPE html><title>T</title>`))
+	assert.NoError(t, err)
+	assert.NotZero(t, n)
+	assert.Equal(t, int64(w.Len()), n)
 }
@@ -0,0 +1,47 @@
+package formathtml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// FormatFS formats the files in fsys whose paths match pattern as HTML
+// fragments and returns their formatted contents by path. Pattern uses the
+// syntax of fs.Glob. A file that cannot be read or formatted does not stop the
+// others from being formatted; its error is returned together with those of
+// the other files and it is left out of the map.
+func FormatFS(fsys fs.FS, pattern string, opts FormatOptions) (map[string]string, error) {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	formatted := make(map[string]string, len(paths))
+	var errs []error
+	for _, path := range paths {
+		out, err := formatFSFile(fsys, path, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		formatted[path] = out
+	}
+
+	return formatted, errors.Join(errs...)
+}
+
+func formatFSFile(fsys fs.FS, path string, opts FormatOptions) (string, error) {
+	src, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err = FragmentWithOptions(&out, bytes.NewReader(src), opts); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
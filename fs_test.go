@@ -0,0 +1,53 @@
+package formathtml
+
+import (
+	"io/fs"
+	"path"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":          {Data: []byte("<div><p>Hello</p></div>")},
+		"partials/nav.html":   {Data: []byte("<ul><li>One</li></ul>")},
+		"partials/footer.tpl": {Data: []byte("<footer>x</footer>")},
+		"style.css":           {Data: []byte("p { color: red }")},
+	}
+
+	formatted, err := FormatFS(fsys, "*.html", DefaultOptions())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"index.html": "<div>\n  <p>Hello</p>\n</div>\n",
+	}, formatted)
+
+	formatted, err = FormatFS(fsys, "partials/*.html", DefaultOptions())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"partials/nav.html": "<ul>\n  <li>One</li>\n</ul>\n",
+	}, formatted)
+}
+
+func TestFormatFSBadPattern(t *testing.T) {
+	_, err := FormatFS(fstest.MapFS{}, "[", DefaultOptions())
+	assert.ErrorIs(t, err, path.ErrBadPattern)
+}
+
+func TestFormatFSCollectsFileErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.html": {Data: []byte("<p>A</p>")},
+		"b.html": {Mode: fs.ModeDir},
+		"c.html": {Data: []byte("<p>C</p>")},
+	}
+
+	formatted, err := FormatFS(fsys, "*.html", DefaultOptions())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "b.html")
+	assert.Equal(t, map[string]string{
+		"a.html": "<p>A</p>\n",
+		"c.html": "<p>C</p>\n",
+	}, formatted)
+}
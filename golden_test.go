@@ -0,0 +1,51 @@
+package formathtml
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// Formats each testdata/golden/*.html file with normalization on and compares
+// it to the .golden file next to it. Files starting with "document-" are
+// formatted as documents. Run with -update after an intended change.
+func TestGoldenFiles(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "golden", "*.html"))
+	require.NoError(t, err)
+
+	for _, path := range paths {
+		name := filepath.Base(path)
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			opts := DefaultOptions()
+			opts.Normalize = true
+
+			var actual bytes.Buffer
+			if strings.HasPrefix(name, "document-") {
+				err = DocumentWithOptions(&actual, bytes.NewReader(input), opts)
+			} else {
+				err = FragmentWithOptions(&actual, bytes.NewReader(input), opts)
+			}
+			require.NoError(t, err)
+
+			goldenPath := strings.TrimSuffix(path, ".html") + ".golden"
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(goldenPath, actual.Bytes(), 0o644))
+			}
+
+			expected, err := os.ReadFile(goldenPath)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), actual.String())
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package formathtml
+
+import (
+	"io"
+
+	"golang.org/x/net/html"
+)
+
+// Records the indentation level of each line written through it.
+type levelRecorder struct {
+	writer  io.Writer
+	level   int
+	levels  []int
+	midLine bool
+}
+
+func (l *levelRecorder) Write(p []byte) (n int, err error) {
+	for _, b := range p {
+		if !l.midLine {
+			l.levels = append(l.levels, l.level)
+			l.midLine = true
+		}
+		if b == '\n' {
+			l.midLine = false
+		}
+	}
+
+	return l.writer.Write(p)
+}
+
+// Sets the level of lines that start after this point.
+func (f *formatter) setLevel(level int) {
+	if f.levels != nil {
+		f.levels.level = level
+	}
+}
+
+// DocumentLevels formats a HTML document like DocumentWithOptions and also
+// returns the indentation level of each line of the output. Lines that are
+// printed as is, like the contents of <pre>, get the level of their element.
+func DocumentLevels(w io.Writer, r io.Reader, opts FormatOptions) (levels []int, err error) {
+	node, err := html.ParseWithOptions(r, html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return nil, stageError(ParseStage, err)
+	}
+	return nodesLevels(w, []*html.Node{node}, opts)
+}
+
+// FragmentLevels formats a fragment of a HTML document like
+// FragmentWithOptions and also returns the indentation level of each line of
+// the output.
+func FragmentLevels(w io.Writer, r io.Reader, opts FormatOptions) (levels []int, err error) {
+	nodes, err := parseFragment(r)
+	if err != nil {
+		return nil, stageError(ParseStage, err)
+	}
+	return nodesLevels(w, nodes, opts)
+}
+
+func nodesLevels(w io.Writer, nodes []*html.Node, opts FormatOptions) ([]int, error) {
+	f := newFormatter(opts)
+	f.levels = &levelRecorder{}
+	err := f.format(w, nodes)
+
+	return f.levels.levels, err
+}
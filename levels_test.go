@@ -0,0 +1,56 @@
+package formathtml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		name     string
+		document bool
+		input    string
+		expected []int
+	}{
+		{
+			name:     "nested blocks and inline content in a fragment",
+			input:    `<div><p>Some <b>bold</b> text</p><ul><li><a href="x">x</a></li></ul></div>`,
+			expected: []int{0, 1, 1, 2, 3, 2, 1, 0},
+		},
+		{
+			name:     "raw text and preformatted lines get the level of their content or element",
+			input:    "<div><script>\n  var a = 1;\n  if (a) {\n    a++;\n  }\n</script><pre>a\n b</pre></div>",
+			expected: []int{0, 1, 2, 2, 2, 2, 1, 1, 1, 0},
+		},
+		{
+			name:     "a document with styles and inline content",
+			document: true,
+			input:    "<!DOCTYPE html><html><head><title>T</title><style>\np { color: red }\n</style></head><body><div><span>x</span> y</div></body></html>",
+			expected: []int{0, 0, 0, 1, 1, 2, 1, 0, 0, 1, 2, 2, 1, 0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			levelsFunc := FragmentLevels
+			format := FragmentWithOptions
+			if test.document {
+				levelsFunc = DocumentLevels
+				format = DocumentWithOptions
+			}
+
+			var b strings.Builder
+			levels, err := levelsFunc(&b, strings.NewReader(test.input), DefaultOptions())
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, levels)
+
+			var formatted strings.Builder
+			require.NoError(t, format(&formatted, strings.NewReader(test.input), DefaultOptions()))
+			assert.Equal(t, formatted.String(), b.String())
+			assert.Len(t, levels, strings.Count(b.String(), "\n"))
+		})
+	}
+}
@@ -4,6 +4,21 @@ import (
 	"bytes"
 	"io"
 	"unicode"
+	"unicode/utf8"
+)
+
+// LeadingSpacePolicy decides when LineOrPassWriter keeps the whitespace
+// written before the first non-space character.
+type LeadingSpacePolicy int
+
+const (
+	// LeadingSpaceIfMultiline keeps leading whitespace only if a newline is
+	// written after it.
+	LeadingSpaceIfMultiline LeadingSpacePolicy = iota
+	// LeadingSpaceKeep always keeps leading whitespace.
+	LeadingSpaceKeep
+	// LeadingSpaceDiscard always discards leading whitespace.
+	LeadingSpaceDiscard
 )
 
 type LineOrPassWriter struct {
@@ -13,8 +28,14 @@ type LineOrPassWriter struct {
 	leadingSpaceBuffer bytes.Buffer
 	lineBuffer         bytes.Buffer
 
+	// partial holds the first bytes of a rune whose remaining bytes have not
+	// been written yet
+	partial []byte
+
 	lineBufferStart       bool
 	endOfFirstLineReached bool
+
+	leadingSpacePolicy LeadingSpacePolicy
 }
 
 // NewLineOrPassWriter creates a new LineOrPassWriter.
@@ -24,6 +45,12 @@ func NewLineOrPassWriter(writer io.Writer) *LineOrPassWriter {
 	}
 }
 
+// SetLeadingSpacePolicy sets when leading whitespace is kept. It is only
+// kept if a newline is written after it by default.
+func (l *LineOrPassWriter) SetLeadingSpacePolicy(policy LeadingSpacePolicy) {
+	l.leadingSpacePolicy = policy
+}
+
 func (l *LineOrPassWriter) IsEndOfFirstLineReached() bool {
 	return l.endOfFirstLineReached
 }
@@ -34,7 +61,11 @@ func (l *LineOrPassWriter) Write(bytes []byte) (n int, err error) {
 		return l.writer.Write(bytes)
 	}
 
-	for _, b := range string(bytes) {
+	data := append(l.partial, bytes...)
+	complete := completeRunesEnd(data)
+	l.partial = append([]byte(nil), data[complete:]...)
+
+	for _, b := range string(data[:complete]) {
 		if l.endOfFirstLineReached {
 			l.lineBuffer.WriteRune(b)
 			continue
@@ -61,18 +92,49 @@ func (l *LineOrPassWriter) Write(bytes []byte) (n int, err error) {
 	return 0, nil
 }
 
+func (l *LineOrPassWriter) keepsLeadingSpace() bool {
+	switch l.leadingSpacePolicy {
+	case LeadingSpaceKeep:
+		return true
+	case LeadingSpaceDiscard:
+		return false
+	}
+
+	return l.endOfFirstLineReached
+}
+
+// Returns the length of data without the bytes at its end that only start a
+// multibyte rune.
+func completeRunesEnd(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+
+	return len(data)
+}
+
 // Drain signals that no new data will be written and flushes the buffers.
-// Leading whitespace is discarded if the string is a single line.
+// Leading whitespace is discarded if the string is a single line, unless the
+// leading space policy says otherwise.
 func (l *LineOrPassWriter) Drain() (n int, err error) {
 	var n64 int64
 
-	if l.endOfFirstLineReached {
+	if l.keepsLeadingSpace() {
 		n64, err = l.leadingSpaceBuffer.WriteTo(l.writer)
 		if err != nil {
 			return int(n64), err
 		}
 	}
 
+	// bytes of an unfinished rune are written as they are
+	l.lineBuffer.Write(l.partial)
+	l.partial = nil
+
 	n64b, err := l.lineBuffer.WriteTo(l.writer)
 	n64 += n64b
 
@@ -48,12 +48,74 @@ func TestLineOrPass(t *testing.T) {
 			inputs:   []string{"\t  ", `foo bar `, "\nbaz", " qux", " quux\n", "corge"},
 			expected: "\t  foo bar \nbaz qux quux\ncorge",
 		},
+		{
+			name:     "rune written one byte at a time is kept intact",
+			inputs:   []string{"\xe2", "\x82", "\xac"},
+			expected: "€",
+		},
+		{
+			name:     "rune split across writes around leading spaces and a newline",
+			inputs:   []string{"  a\xe2", "\x82\xac b\n\xe2\x82", "\xac"},
+			expected: "  a€ b\n€",
+		},
+		{
+			name:     "unfinished rune is drained as is",
+			inputs:   []string{"a\xe2\x82"},
+			expected: "a\xe2\x82",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := new(strings.Builder)
+			lopWriter := NewLineOrPassWriter(w)
+			for _, input := range test.inputs {
+				lopWriter.Write([]byte(input))
+			}
+			lopWriter.Drain()
+			assert.Equal(t, test.expected, w.String())
+		})
+	}
+}
+
+func TestLineOrPassLeadingSpacePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   LeadingSpacePolicy
+		inputs   []string
+		expected string
+	}{
+		{
+			name:     "kept on a single line",
+			policy:   LeadingSpaceKeep,
+			inputs:   []string{"\t  ", `foo bar`},
+			expected: "\t  foo bar",
+		},
+		{
+			name:     "kept with a newline",
+			policy:   LeadingSpaceKeep,
+			inputs:   []string{"\t  ", "foo\nbar"},
+			expected: "\t  foo\nbar",
+		},
+		{
+			name:     "discarded on a single line",
+			policy:   LeadingSpaceDiscard,
+			inputs:   []string{"\t  ", `foo bar`},
+			expected: "foo bar",
+		},
+		{
+			name:     "discarded with a newline",
+			policy:   LeadingSpaceDiscard,
+			inputs:   []string{"\t  ", "foo\n", "bar"},
+			expected: "foo\nbar",
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			w := new(strings.Builder)
 			lopWriter := NewLineOrPassWriter(w)
+			lopWriter.SetLeadingSpacePolicy(test.policy)
 			for _, input := range test.inputs {
 				lopWriter.Write([]byte(input))
 			}
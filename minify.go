@@ -0,0 +1,138 @@
+package formathtml
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Minify writes a fragment of a HTML document with the whitespace that does not
+// change how it is rendered removed. Whitespace between block elements and
+// indentation are dropped and runs of whitespace in text are collapsed to a
+// single space, except inside pre, textarea, script and style elements and
+// raw text elements like iframe and xmp whose contents are kept as is.
+func Minify(w io.Writer, r io.Reader) error {
+	nodes, err := parseFragment(r)
+	if err != nil {
+		return stageError(ParseStage, err)
+	}
+
+	bw := bufio.NewWriter(w)
+	m := &minifier{f: newFormatter(FormatOptions{})}
+	if err = m.nodes(bw, nil, nodes); err != nil {
+		bw.Flush()
+		return stageError(RenderStage, err)
+	}
+
+	return stageError(RenderStage, bw.Flush())
+}
+
+type minifier struct {
+	// f prints the tags so that they look the same as when formatting
+	f *formatter
+}
+
+// Prints the nodes, which are the children of parent or the top level nodes
+// of a fragment when parent is nil.
+func (m *minifier) nodes(w io.Writer, parent *html.Node, nodes []*html.Node) error {
+	for i, n := range nodes {
+		var prev, next *html.Node
+		if i > 0 {
+			prev = nodes[i-1]
+		}
+		if i < len(nodes)-1 {
+			next = nodes[i+1]
+		}
+
+		if err := m.node(w, parent, prev, n, next); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *minifier) node(w io.Writer, parent, prev, n, next *html.Node) (err error) {
+	switch n.Type {
+	case html.TextNode:
+		_, err = io.WriteString(w, minifiedText(parent, prev, n, next))
+	case html.ElementNode:
+		err = m.element(w, n)
+	case html.CommentNode:
+		_, err = fmt.Fprintf(w, "<!--%s-->", n.Data)
+	case html.DoctypeNode:
+		err = html.Render(w, n)
+	case html.DocumentNode:
+		err = m.nodes(w, n, childNodes(n))
+	}
+
+	return
+}
+
+func (m *minifier) element(w io.Writer, n *html.Node) error {
+	if isPre(n, 0, 0) || isTextarea(n, 0, 0) || isSpecialContentElement(n, 0, 0) || hasPreWhiteSpaceStyle(n, 0, 0) {
+		return html.Render(w, n)
+	}
+
+	if _, err := m.f.printOpeningTag(w, n, 0, 0); err != nil {
+		return err
+	}
+	if isEmptyElement(n, 0, 0) {
+		return nil
+	}
+	if err := m.nodes(w, n, childNodes(n)); err != nil {
+		return err
+	}
+	_, err := m.f.printClosingTag(w, n, 0, 0)
+
+	return err
+}
+
+func childNodes(n *html.Node) []*html.Node {
+	var children []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, child)
+	}
+
+	return children
+}
+
+// Returns the escaped text with whitespace runs collapsed to a single space.
+// Whitespace next to a block element or at the edges of one is dropped, but
+// whitespace between text and inline elements is kept as it separates words.
+// The text of raw text elements like iframe and xmp is returned as is since
+// the parser does not decode it.
+func minifiedText(parent, prev, n, next *html.Node) string {
+	if isUndecodedTextElement(parent) {
+		return n.Data
+	}
+
+	s := collapseHTMLSpace(n.Data)
+	if (prev == nil && !isInlineParent(parent)) || (prev != nil && !flowsInline(prev)) {
+		s = strings.TrimLeft(s, " ")
+	}
+	if (next == nil && !isInlineParent(parent)) || (next != nil && !flowsInline(next)) {
+		s = strings.TrimRight(s, " ")
+	}
+
+	return html.EscapeString(s)
+}
+
+func isInlineParent(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode && isInlineElement(n, 0, 0)
+}
+
+// Does the node sit in a line of text rather than start a block?
+func flowsInline(n *html.Node) bool {
+	switch n.Type {
+	case html.TextNode, html.CommentNode:
+		return true
+	case html.ElementNode:
+		return isInlineElement(n, 0, 0)
+	}
+
+	return false
+}
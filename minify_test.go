@@ -0,0 +1,74 @@
+package formathtml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinify(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "whitespace between block elements is dropped",
+			input:    "<div>\n  <h1> Title </h1>\n  <ul> <li> one </li> <li>two</li> </ul>\n</div>\n",
+			expected: `<div><h1>Title</h1><ul><li>one</li><li>two</li></ul></div>`,
+		},
+		{
+			name:     "whitespace between inline elements is kept",
+			input:    "<p>a <b>b</b> c</p>",
+			expected: `<p>a <b>b</b> c</p>`,
+		},
+		{
+			name:     "whitespace runs in text are collapsed",
+			input:    "<p>Some   text\n    over\tlines <i> italic </i>.</p>",
+			expected: `<p>Some text over lines <i> italic </i>.</p>`,
+		},
+		{
+			name:     "pre, textarea, script and style are kept as is",
+			input:    "<div>\n<pre>  keep\n    this</pre>\n<textarea>  a\n b</textarea>\n<script> if (a < 2)  { b() } </script>\n<style> p  { color: red } </style>\n</div>",
+			expected: "<div><pre>  keep\n    this</pre><textarea>  a\n b</textarea><script> if (a < 2)  { b() } </script><style> p  { color: red } </style></div>",
+		},
+		{
+			name:     "raw text elements are kept as is",
+			input:    "<div><noembed><b>x</b>  y</noembed><iframe>a<b  &amp;</iframe><noframes> <p>a</p> </noframes><xmp>a < b</xmp></div>",
+			expected: "<div><noembed><b>x</b>  y</noembed><iframe>a<b  &amp;</iframe><noframes> <p>a</p> </noframes><xmp>a < b</xmp></div>",
+		},
+		{
+			name:     "text is escaped",
+			input:    "<p>a &amp; b &lt; c</p>",
+			expected: `<p>a &amp; b &lt; c</p>`,
+		},
+		{
+			name:     "comments and void elements",
+			input:    "<div> <!-- note --> <br> <img src=\"a.png\" alt=\"\"> </div>",
+			expected: `<div><!-- note --> <br> <img src="a.png" alt=""></div>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, Minify(&b, strings.NewReader(test.input)))
+			assert.Equal(t, test.expected, b.String())
+		})
+	}
+}
+
+func TestMinifyFormattedOutput(t *testing.T) {
+	input := `<div class="page"><h1>Title</h1><p>Some <b>bold</b> and <i>italic</i> text that goes on for long enough to be wrapped over more than one line when it is formatted.</p><ul><li>One</li><li>Two</li></ul><pre>  keep
+    this</pre></div>`
+
+	var minified, pretty, minifiedPretty strings.Builder
+	require.NoError(t, Minify(&minified, strings.NewReader(input)))
+	require.NoError(t, Fragment(&pretty, strings.NewReader(input)))
+	require.NoError(t, Minify(&minifiedPretty, strings.NewReader(pretty.String())))
+
+	assert.NotEqual(t, pretty.String(), minified.String())
+	assert.Equal(t, minified.String(), minifiedPretty.String())
+}
@@ -0,0 +1,83 @@
+package formathtml
+
+import (
+	"bytes"
+	"io"
+)
+
+// Mode is how an input is parsed, as a whole document or as a fragment.
+type Mode int
+
+const (
+	// FragmentMode parses the input as a fragment of a document.
+	FragmentMode Mode = iota
+	// DocumentMode parses the input as a whole document.
+	DocumentMode
+)
+
+func (m Mode) String() string {
+	if m == DocumentMode {
+		return "document"
+	}
+
+	return "fragment"
+}
+
+// DetectMode reads r to tell if it holds a whole HTML document or a fragment
+// of one. It is a document if it begins with a doctype or an html tag, or
+// if it has a head or body tag anywhere. Since r is read to the end, the
+// returned reader replays what was read so that it can still be formatted.
+func DetectMode(r io.Reader) (Mode, io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return FragmentMode, bytes.NewReader(data), err
+	}
+
+	return detectMode(data), bytes.NewReader(data), nil
+}
+
+func detectMode(data []byte) Mode {
+	lower := bytes.ToLower(data)
+	start := bytes.TrimLeft(bytes.TrimPrefix(lower, []byte("\ufeff")), htmlSpace)
+	if bytes.HasPrefix(start, []byte("<!doctype")) || hasTagAt(start, "html") {
+		return DocumentMode
+	}
+	if containsTag(lower, "head") || containsTag(lower, "body") {
+		return DocumentMode
+	}
+
+	return FragmentMode
+}
+
+// Does the lower cased data have an opening tag with the name anywhere?
+func containsTag(data []byte, name string) bool {
+	for i := bytes.IndexByte(data, '<'); i >= 0; {
+		if hasTagAt(data[i:], name) {
+			return true
+		}
+		next := bytes.IndexByte(data[i+1:], '<')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return false
+}
+
+// Does the lower cased data start with an opening tag with the name?
+func hasTagAt(data []byte, name string) bool {
+	if !bytes.HasPrefix(data, []byte("<"+name)) {
+		return false
+	}
+	if len(data) == len(name)+1 {
+		return false
+	}
+
+	switch data[len(name)+1] {
+	case '>', '/', ' ', '\t', '\n', '\f', '\r':
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package formathtml
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Mode
+	}{
+		{
+			name:     "doctype",
+			input:    "\n  <!DOCTYPE html><title>x</title>",
+			expected: DocumentMode,
+		},
+		{
+			name:     "html tag",
+			input:    `<HTML lang="en"><p>x</p></HTML>`,
+			expected: DocumentMode,
+		},
+		{
+			name:     "byte order mark before the doctype",
+			input:    "\ufeff<!doctype html>",
+			expected: DocumentMode,
+		},
+		{
+			name:     "head tag",
+			input:    "<!-- page -->\n<head><title>x</title></head>",
+			expected: DocumentMode,
+		},
+		{
+			name:     "body tag",
+			input:    "<!-- page -->\n<body class=\"home\"><p>x</p></body>",
+			expected: DocumentMode,
+		},
+		{
+			name:     "elements that are not html, head or body",
+			input:    `<header><p>x</p></header><htmlish></htmlish><bodyguard></bodyguard>`,
+			expected: FragmentMode,
+		},
+		{
+			name:     "fragment",
+			input:    `<div><p>x</p></div>`,
+			expected: FragmentMode,
+		},
+		{
+			name:     "empty",
+			input:    ``,
+			expected: FragmentMode,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mode, r, err := DetectMode(strings.NewReader(test.input))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, mode)
+
+			replayed, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, test.input, string(replayed))
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package formathtml
+
+import "io"
+
+// Option changes a setting of Format.
+type Option func(*config)
+
+type config struct {
+	opts     FormatOptions
+	document bool
+}
+
+// WithIndent sets the string printed once for every level of indentation.
+func WithIndent(indent string) Option {
+	return func(c *config) {
+		c.opts.Indent = indent
+	}
+}
+
+// WithWrapWidth sets the column after which paragraphs are wrapped. Zero
+// disables wrapping.
+func WithWrapWidth(width uint) Option {
+	return func(c *config) {
+		c.opts.MaxWidth = width
+	}
+}
+
+// WithDocument sets whether the input is parsed as a whole document instead
+// of a fragment.
+func WithDocument(document bool) Option {
+	return func(c *config) {
+		c.document = document
+	}
+}
+
+// WithLineEnding sets the string printed at the end of every line.
+func WithLineEnding(lineEnding string) Option {
+	return func(c *config) {
+		c.opts.LineEnding = lineEnding
+	}
+}
+
+// Format formats the HTML read from r, as a fragment unless WithDocument is
+// given, with the default options changed by the given ones.
+func Format(w io.Writer, r io.Reader, options ...Option) error {
+	c := config{opts: DefaultOptions()}
+	for _, option := range options {
+		option(&c)
+	}
+
+	if c.document {
+		return DocumentWithOptions(w, r, c.opts)
+	}
+
+	return FragmentWithOptions(w, r, c.opts)
+}
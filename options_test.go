@@ -0,0 +1,53 @@
+package formathtml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		options  []Option
+		expected string
+	}{
+		{
+			name:     "fragment with the default options",
+			input:    `<ul><li>A</li></ul>`,
+			expected: "<ul>\n  <li>A</li>\n</ul>\n",
+		},
+		{
+			name:     "indent and line ending",
+			input:    `<ul><li>A</li></ul>`,
+			options:  []Option{WithIndent("\t"), WithLineEnding("\r\n")},
+			expected: "<ul>\r\n\t<li>A</li>\r\n</ul>\r\n",
+		},
+		{
+			name:     "wrap width",
+			input:    `<p>one two three four</p>`,
+			options:  []Option{WithWrapWidth(12)},
+			expected: "<p>\n  one two\n  three four\n</p>\n",
+		},
+		{
+			name:     "document",
+			input:    `<title>T</title>`,
+			options:  []Option{WithDocument(true)},
+			expected: "<html>\n<head>\n  <title>T</title>\n</head>\n<body></body>\n</html>\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			w := new(strings.Builder)
+			if err := Format(w, strings.NewReader(test.input), test.options...); err != nil {
+				t.Fatalf("failed to format: %v", err)
+			}
+			assert.Equal(t, test.expected, w.String())
+		})
+	}
+}
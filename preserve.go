@@ -0,0 +1,207 @@
+package formathtml
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Comments that turn formatting off and back on. The siblings between a
+// <!-- formathtml:off --> comment and the next <!-- formathtml:on --> comment
+// are printed exactly as they were written in the source. The source is
+// tokenized again to find them, so it must be well-formed enough for the
+// comments to be tokenized where the parser puts them, and the two comments
+// must end up as siblings in the same block element. Regions whose source
+// cannot be found this way, like those inside <noscript>, are formatted like
+// the rest. Directives inside wrapped paragraphs are ignored.
+const (
+	formatOffDirective = "formathtml:off"
+	formatOnDirective  = "formathtml:on"
+)
+
+func isFormatDirective(n *html.Node, directive string) bool {
+	return n.Type == html.CommentNode && strings.TrimSpace(n.Data) == directive
+}
+
+// Returns the nodes from the formatting off comment at the start of nodes to
+// the formatting on comment that ends its region, or nil if nodes does not
+// start with an off comment or the region is not closed among them.
+func preservedRegion(nodes []*html.Node) []*html.Node {
+	if len(nodes) == 0 || !isFormatDirective(nodes[0], formatOffDirective) {
+		return nil
+	}
+
+	for i, n := range nodes[1:] {
+		if isFormatDirective(n, formatOnDirective) {
+			return nodes[:i+2]
+		}
+	}
+
+	return nil
+}
+
+// Returns the node and the siblings that follow it.
+func siblingsFrom(n *html.Node) []*html.Node {
+	var nodes []*html.Node
+	for ; n != nil; n = n.NextSibling {
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+// Returns the preserved region that starts at the first of the nodes. Regions
+// whose source could not be found are formatted like the rest when the
+// formatter has the source.
+func (f *formatter) preservedRegion(nodes []*html.Node) []*html.Node {
+	region := preservedRegion(nodes)
+	if region != nil && f.preserved != nil {
+		if _, ok := f.preserved[region[0]]; !ok {
+			return nil
+		}
+	}
+
+	return region
+}
+
+// Maps the formatting off comments of the parsed nodes to the source between
+// them and the on comment that ends their region. The tokenizer does not read
+// every element the way the parser does, like <noscript>, whose contents it
+// sees as text, so a region of the source is only used for an off comment
+// when it parses to the same nodes as its region in the tree.
+func preservedSources(src []byte, nodes []*html.Node) map[*html.Node]string {
+	if !bytes.Contains(src, []byte(formatOffDirective)) {
+		return nil
+	}
+
+	var regions [][]*html.Node
+	var walk func(nodes []*html.Node)
+	walk = func(nodes []*html.Node) {
+		for i := 0; i < len(nodes); i++ {
+			if region := preservedRegion(nodes[i:]); region != nil {
+				regions = append(regions, region)
+				i += len(region) - 1
+				continue
+			}
+			walk(childNodes(nodes[i]))
+		}
+	}
+	walk(nodes)
+
+	sources := map[*html.Node]string{}
+	next := 0
+	candidates := sourceRegions(src)
+	for _, region := range regions {
+		for i := next; i < len(candidates); i++ {
+			if candidates[i].closed && parsesTo(candidates[i].source, region) {
+				sources[region[0]] = candidates[i].source
+				next = i + 1
+				break
+			}
+		}
+	}
+
+	return sources
+}
+
+// Does the source parse to the nodes between the off and on comments of the
+// region?
+func parsesTo(source string, region []*html.Node) bool {
+	context := region[0].Parent
+	if context == nil || context.Type != html.ElementNode {
+		context = &html.Node{Type: html.ElementNode}
+	}
+
+	parsed, err := html.ParseFragmentWithOptions(strings.NewReader(source), context, html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return false
+	}
+
+	var want, got bytes.Buffer
+	for _, n := range region[1 : len(region)-1] {
+		if html.Render(&want, n) != nil {
+			return false
+		}
+	}
+	for _, n := range parsed {
+		if html.Render(&got, n) != nil {
+			return false
+		}
+	}
+
+	return want.String() == got.String()
+}
+
+type sourceRegion struct {
+	source string
+	closed bool
+}
+
+// Returns a region for every formatting off comment in the source, in order.
+// Only off comments that are not already in a region get the source up to the
+// next on comment.
+func sourceRegions(src []byte) []sourceRegion {
+	var regions []sourceRegion
+	open := -1
+	start, offset := 0, 0
+
+	z := html.NewTokenizer(bytes.NewReader(src))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return regions
+		}
+
+		raw := len(z.Raw())
+		if tt == html.CommentToken {
+			switch data := strings.TrimSpace(string(z.Text())); {
+			case data == formatOffDirective:
+				regions = append(regions, sourceRegion{})
+				if open < 0 {
+					open = len(regions) - 1
+					start = offset + raw
+				}
+			case data == formatOnDirective && open >= 0:
+				regions[open] = sourceRegion{source: string(src[start:offset]), closed: true}
+				open = -1
+			}
+		}
+		offset += raw
+	}
+}
+
+// Prints the region from the formatting off comment to the on comment as it
+// was written in the source, or as the parser read it when there is no
+// source.
+func (f *formatter) printPreservedRegion(w io.Writer, region []*html.Node, level int, col uint) (colAfter uint, err error) {
+	if _, err = f.printIndent(w, nil, level, col); err != nil {
+		return
+	}
+
+	off, on := region[0], region[len(region)-1]
+	if _, err = fmt.Fprintf(w, "<!--%s-->", off.Data); err != nil {
+		return
+	}
+
+	if source, ok := f.preserved[off]; ok {
+		f.keepingTrailingWhitespace(func() {
+			_, err = io.WriteString(w, source)
+		})
+	} else {
+		for _, n := range region[1 : len(region)-1] {
+			if err = html.Render(w, n); err != nil {
+				return
+			}
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "<!--%s-->%s", on.Data, f.lineEnding())
+
+	return 0, err
+}
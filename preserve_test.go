@@ -0,0 +1,132 @@
+package formathtml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+func TestFragmentFormatOffDirective(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "a hand aligned table between off and on comments is kept as written",
+			input: `<div>
+<p>Before</p>
+    <!-- formathtml:off -->
+    <table>
+      <tr><td>1</td>   <td align=right>10</td></tr>
+      <tr><td>22</td>  <td align=right>200</td></tr>
+    </table>
+    <!-- formathtml:on -->
+<p>After</p>
+</div>`,
+			expected: `<div>
+  <p>Before</p>
+  <!-- formathtml:off -->
+    <table>
+      <tr><td>1</td>   <td align=right>10</td></tr>
+      <tr><td>22</td>  <td align=right>200</td></tr>
+    </table>
+    <!-- formathtml:on -->
+  <p>After</p>
+</div>
+`,
+		},
+		{
+			name:  "top level regions are kept as written",
+			input: "<ul><li>One</li></ul>\n<!--formathtml:off--><ul>\n<li>Two</li>   <li>Three</li>\n</ul><!--formathtml:on-->\n<ul><li>Four</li></ul>",
+			expected: `<ul>
+  <li>One</li>
+</ul>
+<!--formathtml:off--><ul>
+<li>Two</li>   <li>Three</li>
+</ul><!--formathtml:on-->
+<ul>
+  <li>Four</li>
+</ul>
+`,
+		},
+		{
+			name:  "off comments inside a region are part of it",
+			input: "<div><!-- formathtml:off --><b>a</b>  <!-- formathtml:off -->  <i>b</i><!-- formathtml:on --><p>c</p></div>",
+			expected: `<div>
+  <!-- formathtml:off --><b>a</b>  <!-- formathtml:off -->  <i>b</i><!-- formathtml:on -->
+  <p>c</p>
+</div>
+`,
+		},
+		{
+			name:  "an off comment without an on comment is ignored",
+			input: "<div><!-- formathtml:off --><p>a</p></div><div><!-- formathtml:on --><p>b</p></div>",
+			expected: `<div>
+  <!-- formathtml:off -->
+  <p>a</p>
+</div>
+<div>
+  <!-- formathtml:on -->
+  <p>b</p>
+</div>
+`,
+		},
+		{
+			name:  "regions inside elements the tokenizer reads as text are formatted",
+			input: "<noscript><!-- formathtml:off --><p>a</p><!-- formathtml:on --></noscript><div><!-- formathtml:off --><b>X</b>  <i>y</i><!-- formathtml:on --></div>",
+			expected: `<noscript>
+  <!-- formathtml:off -->
+  <p>a</p>
+  <!-- formathtml:on -->
+</noscript>
+<div>
+  <!-- formathtml:off --><b>X</b>  <i>y</i><!-- formathtml:on -->
+</div>
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, Fragment(&b, strings.NewReader(test.input)))
+			assert.Equal(t, test.expected, b.String())
+			assertIdempotent(t, b.String(), DefaultOptions())
+		})
+	}
+}
+
+func TestDocumentFormatOffDirective(t *testing.T) {
+	input := `<!DOCTYPE html><html><head><!-- formathtml:off --><script>var a  =  1;</script>
+<style>p { color: red }</style><!-- formathtml:on --></head><body><p>Hi</p></body></html>`
+
+	var b strings.Builder
+	require.NoError(t, Document(&b, strings.NewReader(input)))
+	assert.Equal(t, `<!DOCTYPE html>
+<html>
+<head>
+  <!-- formathtml:off --><script>var a  =  1;</script>
+<style>p { color: red }</style><!-- formathtml:on -->
+</head>
+<body>
+  <p>Hi</p>
+</body>
+</html>
+`, b.String())
+}
+
+func TestNodesFormatOffDirectiveWithoutSource(t *testing.T) {
+	nodes, err := parseFragment(strings.NewReader("<div><!-- formathtml:off --><b   class=x>a</b>  <i>b</i><!-- formathtml:on --></div>"))
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, Nodes(&b, []*html.Node{nodes[0]}))
+	assert.Equal(t, `<div>
+  <!-- formathtml:off --><b class="x">a</b>  <i>b</i><!-- formathtml:on -->
+</div>
+`, b.String())
+}
@@ -0,0 +1,360 @@
+package formathtml
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// StreamFragment formats a fragment of a HTML document as it is read instead of
+// parsing the whole document into a tree first, which keeps memory use low for
+// large inputs. Elements are indented by how deeply they are nested and the
+// contents of pre, textarea, script and style elements are kept as is, but
+// unlike Fragment paragraphs are not wrapped and misnested tags are not
+// corrected. Text and inline elements are only moved to their own lines where
+// the source has whitespace between them so that the page reads the same.
+func StreamFragment(w io.Writer, r io.Reader) error {
+	bw := bufio.NewWriter(w)
+	s := &streamFormatter{
+		w:      bw,
+		opts:   DefaultOptions(),
+		atLine: true,
+	}
+	if err := s.format(html.NewTokenizer(r)); err != nil {
+		bw.Flush()
+		var formatErr *FormatError
+		if errors.As(err, &formatErr) {
+			return err
+		}
+		return stageError(RenderStage, err)
+	}
+
+	return stageError(RenderStage, bw.Flush())
+}
+
+type streamFormatter struct {
+	w    io.Writer
+	opts FormatOptions
+
+	// open holds the names of the elements that have not been closed yet
+	open []string
+
+	// verbatim is the name of the element whose contents are being copied
+	// as is, and verbatimDepth counts the nested elements of the same name
+	verbatim      string
+	verbatimDepth int
+
+	// pending is true when an opening tag was printed but not yet the
+	// newline after it, so that an element with only text can be printed on
+	// one line. pendingText holds that text.
+	pending     bool
+	pendingText string
+
+	// inline is true when the last thing printed flows with the text around
+	// it and space when the source has whitespace after it. Only then can
+	// the next inline thing be moved to a new line without showing a space
+	// that is not in the source.
+	inline bool
+	space  bool
+
+	atLine bool
+}
+
+func (s *streamFormatter) format(z *html.Tokenizer) error {
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				if err := s.closeAll(); err != nil {
+					return err
+				}
+				if !s.atLine {
+					return s.newLine()
+				}
+				return nil
+			}
+			return stageError(ParseStage, z.Err())
+		}
+
+		if s.verbatim != "" {
+			if err := s.copyVerbatim(z, tt, string(z.Raw())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var err error
+		switch tt {
+		case html.TextToken:
+			err = s.text(string(z.Raw()))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			err = s.startTag(z.Token(), tt == html.SelfClosingTagToken)
+		case html.EndTagToken:
+			err = s.endTag(z.Token())
+		case html.CommentToken:
+			err = s.item(string(z.Raw()), true)
+		case html.DoctypeToken:
+			err = s.item(string(z.Raw()), false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Copies the tokens inside a verbatim element until its end tag.
+func (s *streamFormatter) copyVerbatim(z *html.Tokenizer, tt html.TokenType, raw string) error {
+	if tt == html.StartTagToken || tt == html.EndTagToken {
+		name, _ := z.TagName()
+		if string(name) == s.verbatim {
+			if tt == html.StartTagToken {
+				s.verbatimDepth++
+			} else {
+				s.verbatimDepth--
+			}
+		}
+	}
+
+	if s.verbatimDepth == 0 {
+		s.inline = isStreamInline(atom.Lookup([]byte(s.verbatim)))
+		s.space = false
+		s.verbatim = ""
+		s.open = s.open[:len(s.open)-1]
+	}
+
+	return s.write(raw)
+}
+
+func (s *streamFormatter) text(raw string) error {
+	text := trimHTMLSpace(raw)
+	if text == "" {
+		s.space = s.space || raw != ""
+		return nil
+	}
+	if text[0] != raw[0] {
+		s.space = true
+	}
+	trailingSpace := text[len(text)-1] != raw[len(raw)-1]
+
+	// the text of an inline element is only kept on the line of its opening
+	// tag when no whitespace comes between them
+	if s.pending && s.pendingText == "" && !(s.inline && s.space) {
+		s.pendingText = text
+		s.space = trailingSpace
+		return nil
+	}
+
+	if err := s.item(text, true); err != nil {
+		return err
+	}
+	s.space = trailingSpace
+	return nil
+}
+
+func (s *streamFormatter) startTag(t html.Token, selfClosing bool) error {
+	// a list item, paragraph or table cell ends the one before it if that was
+	// not closed
+	if len(s.open) > 0 && s.open[len(s.open)-1] == t.Data && closesSibling(t.DataAtom) {
+		if err := s.endTag(html.Token{Type: html.EndTagToken, Data: t.Data}); err != nil {
+			return err
+		}
+	}
+
+	void := isEmptyElement(&html.Node{DataAtom: t.DataAtom}, 0, 0)
+	if err := s.item(openingTag(t, selfClosing && !void), isStreamInline(t.DataAtom)); err != nil {
+		return err
+	}
+
+	if selfClosing || void {
+		return nil
+	}
+
+	s.open = append(s.open, t.Data)
+	if isStreamVerbatim(t.DataAtom) {
+		s.verbatim = t.Data
+		s.verbatimDepth = 1
+		return nil
+	}
+
+	s.pending = true
+	return nil
+}
+
+func (s *streamFormatter) endTag(t html.Token) error {
+	i := len(s.open) - 1
+	for i >= 0 && s.open[i] != t.Data {
+		i--
+	}
+	if i < 0 {
+		// an end tag without an opening tag is dropped like the parser does
+		return nil
+	}
+
+	// elements left open inside this one are closed with it
+	for len(s.open) > i {
+		name := s.open[len(s.open)-1]
+		s.open = s.open[:len(s.open)-1]
+		inline := isStreamInline(atom.Lookup([]byte(name)))
+		if !s.pending || inline && s.space {
+			if err := s.item("</"+name+">", inline); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// the element has at most some text so it is printed on one line
+		s.pending = false
+		text := s.pendingText
+		s.pendingText = ""
+		if err := s.write(text + "</" + name + ">"); err != nil {
+			return err
+		}
+		s.inline = inline
+		s.space = false
+	}
+
+	return nil
+}
+
+// Closes the elements that are still open at the end of the input.
+func (s *streamFormatter) closeAll() error {
+	if s.verbatim != "" {
+		// the contents were copied as is so the end tag goes right after
+		if err := s.write("</" + s.verbatim + ">"); err != nil {
+			return err
+		}
+		s.inline = isStreamInline(atom.Lookup([]byte(s.verbatim)))
+		s.space = false
+		s.verbatim = ""
+		s.open = s.open[:len(s.open)-1]
+	}
+	if len(s.open) == 0 {
+		return s.flushPending()
+	}
+
+	return s.endTag(html.Token{Type: html.EndTagToken, Data: s.open[0]})
+}
+
+// Prints the text held back after an opening tag.
+func (s *streamFormatter) flushPending() error {
+	if !s.pending {
+		return nil
+	}
+
+	s.pending = false
+	text := s.pendingText
+	s.pendingText = ""
+	if text == "" {
+		return nil
+	}
+
+	space := s.space
+	if err := s.item(text, true); err != nil {
+		return err
+	}
+	s.space = space
+	return nil
+}
+
+// Prints the string at the current depth on a new line, or on the current one
+// when both it and what comes before are inline and the source has no
+// whitespace between them.
+func (s *streamFormatter) item(str string, inline bool) error {
+	if err := s.flushPending(); err != nil {
+		return err
+	}
+	if s.atLine || !inline || !s.inline || s.space {
+		if err := s.indent(len(s.open)); err != nil {
+			return err
+		}
+	}
+	if err := s.write(str); err != nil {
+		return err
+	}
+
+	s.inline = inline
+	s.space = false
+	return nil
+}
+
+func (s *streamFormatter) indent(level int) error {
+	if !s.atLine {
+		if err := s.newLine(); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < level; i++ {
+		if err := s.write(s.opts.Indent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *streamFormatter) newLine() error {
+	_, err := io.WriteString(s.w, "\n")
+	s.atLine = true
+	return err
+}
+
+func (s *streamFormatter) write(str string) error {
+	if str != "" {
+		s.atLine = false
+	}
+
+	_, err := io.WriteString(s.w, str)
+	return err
+}
+
+// Elements whose end tag can be left out when the next sibling is another
+// element of the same kind.
+func closesSibling(a atom.Atom) bool {
+	switch a {
+	case atom.Li, atom.Dt, atom.Dd, atom.Option, atom.P, atom.Tr, atom.Td, atom.Th:
+		return true
+	}
+
+	return false
+}
+
+// Elements that flow inline with text, including custom elements which
+// browsers show inline and scripts which do not show at all. A <br> is left
+// out as the whitespace around it does not show.
+func isStreamInline(a atom.Atom) bool {
+	return a == 0 || a == atom.Script || a != atom.Br && isInlineElement(&html.Node{DataAtom: a}, 0, 0)
+}
+
+// Elements whose contents the stream formatter copies as is.
+func isStreamVerbatim(a atom.Atom) bool {
+	switch a {
+	case atom.Pre, atom.Textarea, atom.Script, atom.Style:
+		return true
+	}
+
+	return false
+}
+
+func openingTag(t html.Token, selfClosing bool) string {
+	var b strings.Builder
+	b.WriteString("<")
+	b.WriteString(t.Data)
+	for _, a := range t.Attr {
+		b.WriteString(" ")
+		b.WriteString(a.Key)
+		b.WriteString(`="`)
+		b.WriteString(html.EscapeString(a.Val))
+		b.WriteString(`"`)
+	}
+	if selfClosing {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package formathtml
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamFragment(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:  "elements are indented by depth",
+			input: `<div class="a &amp; b"><section><h1>Title</h1><p>Text</p></section></div>`,
+			expected: `<div class="a &amp; b">
+  <section>
+    <h1>Title</h1>
+    <p>Text</p>
+  </section>
+</div>
+`,
+		},
+		{
+			name:  "text separated from elements by whitespace is printed on its own line",
+			input: `<p>Hello <b>there</b> &amp; bye</p>`,
+			expected: `<p>
+  Hello
+  <b>there</b>
+  &amp; bye
+</p>
+`,
+		},
+		{
+			name:  "text and inline elements without whitespace between them stay on one line",
+			input: `<p>un<b>believ</b>able <i><b>x</b></i>, <b> y </b>z<img src="x"></p>`,
+			expected: `<p>
+  un<b>believ</b>able
+  <i><b>x</b></i>,
+  <b>
+    y
+  </b>z<img src="x">
+</p>
+`,
+		},
+		{
+			name:  "void elements are not indented further",
+			input: "<div><br><img src=\"x\"/>\n<input type=\"text\"></div>",
+			expected: `<div>
+  <br>
+  <img src="x">
+  <input type="text">
+</div>
+`,
+		},
+		{
+			name:  "list items without end tags are closed",
+			input: `<ul><li>One<li>Two</ul>`,
+			expected: `<ul>
+  <li>One</li>
+  <li>Two</li>
+</ul>
+`,
+		},
+		{
+			name:  "pre and script contents are kept as is",
+			input: "<div><pre>\n  keep <b>this</b>\n</pre><script>if (a < b) {\n  go();\n}</script></div>",
+			expected: "<div>\n" +
+				"  <pre>\n  keep <b>this</b>\n</pre>\n" +
+				"  <script>if (a < b) {\n  go();\n}</script>\n" +
+				"</div>\n",
+		},
+		{
+			name:  "comments and unclosed elements",
+			input: `<!-- note --><div><span>x`,
+			expected: `<!-- note -->
+<div>
+  <span>x</span>
+</div>
+`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			w := new(strings.Builder)
+			if err := StreamFragment(w, strings.NewReader(test.input)); err != nil {
+				t.Fatalf("failed to format: %v", err)
+			}
+			assert.Equal(t, test.expected, w.String())
+		})
+	}
+}
+
+func largeDocument() string {
+	var b strings.Builder
+	for i := 0; i < 2000; i++ {
+		b.WriteString(`<section class="entry"><h2>Heading</h2><ul><li><a href="/one">One</a></li><li>Two</li></ul>`)
+		b.WriteString(`<div><span>Some text</span><img src="image.png" alt="An image"></div></section>`)
+	}
+
+	return b.String()
+}
+
+func BenchmarkFragmentLarge(b *testing.B) {
+	input := largeDocument()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Fragment(io.Discard, strings.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamFragmentLarge(b *testing.B) {
+	input := largeDocument()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := StreamFragment(io.Discard, strings.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package formathtml
+
+import (
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// Matches the keyword of template actions such as `{{ if .Foo }}` or
+// `{{- end }}`.
+var templateActionPattern = regexp.MustCompile(`\{\{-?\s*(if|range|with|block|define|end)\b`)
+
+type templateAction struct {
+	keyword string
+	node    *html.Node
+}
+
+// Warns about template control actions that are not balanced with an
+// `{{ end }}` in the given nodes. Actions are looked for in text nodes and
+// attribute values.
+func (f *formatter) checkTemplateDirectives(nodes []*html.Node) {
+	var open []templateAction
+	var visit func(n *html.Node)
+
+	scan := func(n *html.Node, s string) {
+		for _, match := range templateActionPattern.FindAllStringSubmatch(s, -1) {
+			keyword := match[1]
+			if keyword != "end" {
+				open = append(open, templateAction{keyword: keyword, node: n})
+				continue
+			}
+
+			if len(open) == 0 {
+				f.warn(n, "{{ end }} has no matching opening action")
+				continue
+			}
+			open = open[:len(open)-1]
+		}
+	}
+
+	visit = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			scan(n, n.Data)
+		case html.ElementNode:
+			for _, a := range n.Attr {
+				scan(n, a.Val)
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+
+	for _, n := range nodes {
+		visit(n)
+	}
+
+	for _, action := range open {
+		f.warn(action.node, "{{ %s }} has no matching {{ end }}", action.keyword)
+	}
+}
@@ -0,0 +1,168 @@
+package formathtml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/net/html"
+)
+
+// Valid checks that a fragment of a HTML document can be parsed. The parser
+// recovers from most authoring mistakes the way browsers do, so this only
+// fails when the input cannot be read. Use ValidStrict to find those
+// mistakes.
+func Valid(r io.Reader) error {
+	_, err := parseFragment(r)
+	return stageError(ParseStage, err)
+}
+
+// ValidationError is an authoring mistake found by ValidStrict.
+type ValidationError struct {
+	// Line is the line of the input the mistake is on, starting at 1.
+	Line    int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("formathtml: line %d: %s", e.Line, e.Message)
+}
+
+// ValidStrict checks a fragment of a HTML document like Valid and also looks
+// for the mistakes the parser silently recovers from: end tags that do not
+// close an open element and elements that are never closed. Tags are matched
+// the way the parser matches them, so the start tag of a block element, like
+// <div>, closes an open paragraph and leaves its end tag without a match.
+// Elements whose end tags may be left out, like <p> and <li>, are not
+// reported. Every mistake is returned as a *ValidationError, joined with
+// errors.Join.
+func ValidStrict(r io.Reader) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return stageError(ParseStage, err)
+	}
+	if err = Valid(bytes.NewReader(src)); err != nil {
+		return err
+	}
+
+	return errors.Join(tagErrors(src)...)
+}
+
+type openTag struct {
+	name string
+	line int
+}
+
+// Returns the mistakes in how the tags of the source are opened and closed.
+func tagErrors(src []byte) []error {
+	var errs []error
+	var open []openTag
+	line := 1
+
+	unclosed := func(tags []openTag) {
+		for _, tag := range tags {
+			if !hasOptionalEndTag(tag.name) {
+				errs = append(errs, &ValidationError{Line: tag.line, Message: fmt.Sprintf("<%s> is not closed", tag.name)})
+			}
+		}
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(src))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		name, _ := z.TagName()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			foreign := inForeignContent(open)
+			if !foreign && closesParagraph(string(name)) {
+				if i := openParagraph(open); i >= 0 {
+					unclosed(open[i+1:])
+					open = open[:i]
+				}
+			}
+
+			// only void and foreign elements, like those in SVG, can be
+			// closed by their start tag
+			selfClosed := tt == html.SelfClosingTagToken && foreign
+			if !IsVoidElement(string(name)) && !selfClosed {
+				open = append(open, openTag{name: string(name), line: line})
+			}
+
+		case html.EndTagToken:
+			i := len(open) - 1
+			for i >= 0 && open[i].name != string(name) {
+				i--
+			}
+			if i < 0 {
+				errs = append(errs, &ValidationError{Line: line, Message: fmt.Sprintf("</%s> has no matching start tag", name)})
+				break
+			}
+
+			unclosed(open[i+1:])
+			open = open[:i]
+		}
+
+		line += bytes.Count(z.Raw(), newlineBytes)
+	}
+	unclosed(open)
+
+	return errs
+}
+
+// Is one of the open elements an SVG or MathML element?
+func inForeignContent(open []openTag) bool {
+	for _, tag := range open {
+		if tag.name == "svg" || tag.name == "math" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the index of the open paragraph that the start tag of a block
+// element would close, or -1 if there is none.
+func openParagraph(open []openTag) int {
+	for i := len(open) - 1; i >= 0; i-- {
+		switch open[i].name {
+		case "p":
+			return i
+		case "applet", "button", "caption", "html", "marquee", "object", "table", "td", "template", "th":
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// Does the start tag of the element close an open paragraph, as block
+// elements cannot be inside one?
+func closesParagraph(name string) bool {
+	switch name {
+	case "address", "article", "aside", "blockquote", "center", "details",
+		"dialog", "dir", "div", "dl", "fieldset", "figcaption", "figure",
+		"footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header",
+		"hgroup", "hr", "listing", "main", "menu", "nav", "ol", "p",
+		"plaintext", "pre", "search", "section", "summary", "table", "ul",
+		"xmp":
+		return true
+	}
+
+	return false
+}
+
+// Can the end tag of the element be left out?
+func hasOptionalEndTag(name string) bool {
+	switch name {
+	case "html", "head", "body", "p", "li", "dt", "dd", "rt", "rp", "optgroup",
+		"option", "colgroup", "caption", "thead", "tbody", "tfoot", "tr", "td", "th":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,80 @@
+package formathtml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValid(t *testing.T) {
+	assert.NoError(t, Valid(strings.NewReader(`<div><p>Hello</div>`)))
+
+	err := Valid(failingReader{})
+	var formatErr *FormatError
+	require.ErrorAs(t, err, &formatErr)
+	assert.Equal(t, ParseStage, formatErr.Stage)
+}
+
+func TestValidStrict(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:  "well-formed fragments are valid",
+			input: "<div class=\"a\">\n  <p>One <b>two</b><br>\n  <img src=\"x.png\">\n</div>",
+		},
+		{
+			name:  "optional end tags may be left out",
+			input: "<ul><li>One<li>Two</ul><table><tr><td>1<td>2</table><p>Text",
+		},
+		{
+			name:  "foreign elements may be self-closed",
+			input: `<svg viewBox="0 0 10 10"><path d="M0 0"/><circle r="1" /></svg>`,
+		},
+		{
+			name:     "elements that are never closed are reported",
+			input:    "<div>\n  <span>text\n</div>\n<section>",
+			expected: []string{"formathtml: line 2: <span> is not closed", "formathtml: line 4: <section> is not closed"},
+		},
+		{
+			name:     "stray end tags are reported",
+			input:    "<div>text</div>\n</span></div>",
+			expected: []string{"formathtml: line 2: </span> has no matching start tag", "formathtml: line 2: </div> has no matching start tag"},
+		},
+		{
+			name:     "block elements close open paragraphs",
+			input:    "<p>a<div>b</div></p>",
+			expected: []string{"formathtml: line 1: </p> has no matching start tag"},
+		},
+		{
+			name:  "blocks inside buttons do not close the paragraph around them",
+			input: "<p>a</p><p><button>b<div>c</div></button></p>",
+		},
+		{
+			name:     "self-closed html elements are not closed",
+			input:    "<div/><p>text</p>",
+			expected: []string{"formathtml: line 1: <div> is not closed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidStrict(strings.NewReader(test.input))
+			if test.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Equal(t, strings.Join(test.expected, "\n"), err.Error())
+
+			var validationErr *ValidationError
+			assert.True(t, errors.As(err, &validationErr))
+		})
+	}
+}
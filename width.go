@@ -0,0 +1,114 @@
+package formathtml
+
+import (
+	"sort"
+	"unicode"
+)
+
+// Ranges of runes that are wide or fullwidth in East Asian contexts and take up
+// two columns in a terminal, sorted by their first rune.
+var wideRuneRanges = [][2]rune{
+	{0x1100, 0x115F},   // Hangul Jamo initial consonants
+	{0x2E80, 0x303E},   // CJK radicals, Kangxi radicals and CJK punctuation
+	{0x3041, 0x33FF},   // Kana, Bopomofo, Hangul compatibility Jamo and CJK compatibility
+	{0x3400, 0x4DBF},   // CJK unified ideographs extension A
+	{0x4E00, 0x9FFF},   // CJK unified ideographs
+	{0xA000, 0xA4CF},   // Yi syllables and radicals
+	{0xA960, 0xA97F},   // Hangul Jamo extended A
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE10, 0xFE19},   // vertical forms
+	{0xFE30, 0xFE6F},   // CJK compatibility forms and small form variants
+	{0xFF00, 0xFF60},   // fullwidth forms
+	{0xFFE0, 0xFFE6},   // fullwidth signs
+	{0x1F300, 0x1F64F}, // pictographs and emoticons
+	{0x1F900, 0x1F9FF}, // supplemental symbols and pictographs
+	{0x20000, 0x2FFFD}, // CJK unified ideographs extensions B to F
+	{0x30000, 0x3FFFD}, // CJK unified ideographs extension G
+}
+
+// EastAsianRuneWidth returns 2 for wide and fullwidth runes, like CJK
+// ideographs, kana and hangul, and 1 for all other runes. It can be used as
+// the RuneWidth of FormatOptions and WrapOptions.
+func EastAsianRuneWidth(r rune) uint {
+	i := sort.Search(len(wideRuneRanges), func(i int) bool {
+		return wideRuneRanges[i][1] >= r
+	})
+	if i < len(wideRuneRanges) && wideRuneRanges[i][0] <= r {
+		return 2
+	}
+
+	return 1
+}
+
+const zeroWidthJoiner = '\u200d'
+
+// Counts the columns text takes up a rune at a time. When counting graphemes,
+// runes that combine with the ones before them, like accents, emoji modifiers
+// and emoji joined by a zero width joiner, take up no columns so that each
+// grapheme cluster is only counted once.
+type widthCounter struct {
+	runeWidth func(r rune) uint
+	graphemes bool
+
+	// joined is true after a zero width joiner and regional after the first
+	// of a pair of regional indicators that make up a flag
+	joined   bool
+	regional bool
+}
+
+// Returns the number of columns the rune adds.
+func (c *widthCounter) add(r rune) uint {
+	if c.graphemes {
+		joined, regional := c.joined, c.regional
+		c.joined, c.regional = r == zeroWidthJoiner, false
+		switch {
+		case joined, isZeroWidth(r):
+			return 0
+		case isRegionalIndicator(r):
+			c.regional = !regional
+			if regional {
+				return 0
+			}
+		}
+	}
+
+	if c.runeWidth == nil {
+		return 1
+	}
+
+	return c.runeWidth(r)
+}
+
+// Returns the number of columns s takes up.
+func (c *widthCounter) width(s string) uint {
+	width := uint(0)
+	for _, r := range s {
+		width += c.add(r)
+	}
+
+	return width
+}
+
+// Returns the number of columns s takes up, counting grapheme clusters instead
+// of runes when graphemes is true.
+func textWidth(s string, runeWidth func(r rune) uint, graphemes bool) uint {
+	if !graphemes {
+		return stringWidth(s, runeWidth)
+	}
+
+	c := widthCounter{runeWidth: runeWidth, graphemes: true}
+	return c.width(s)
+}
+
+// Does the rune combine with the one before it without taking up a column of
+// its own?
+func isZeroWidth(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) ||
+		r >= 0x1160 && r <= 0x11FF || // Hangul Jamo vowels and final consonants
+		r >= 0x1F3FB && r <= 0x1F3FF // emoji skin tone modifiers
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
@@ -0,0 +1,31 @@
+package formathtml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEastAsianRuneWidth(t *testing.T) {
+	tests := []struct {
+		r        rune
+		expected uint
+	}{
+		{'a', 1},
+		{'é', 1},
+		{' ', 1},
+		{'人', 2},
+		{'の', 2},
+		{'カ', 2},
+		{'한', 2},
+		{'Ａ', 2},
+		{'。', 2},
+		{'ｶ', 1}, // halfwidth katakana
+		{'😀', 2},
+		{'𠀀', 2},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, EastAsianRuneWidth(test.r), "width of %q", test.r)
+	}
+}
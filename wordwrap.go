@@ -29,6 +29,8 @@ package formathtml
 import (
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 )
@@ -39,6 +41,41 @@ type WrapOptions struct {
 	Limit       uint
 	StartsAt    uint
 	Indentation string
+
+	// RuneWidth returns the number of columns a rune takes up. Each rune is
+	// one column wide when it is nil.
+	RuneWidth func(r rune) uint
+
+	// GraphemeWidth measures each grapheme cluster, like an accented letter
+	// written with a combining mark or an emoji sequence joined by zero width
+	// joiners, by its first rune only.
+	GraphemeWidth bool
+
+	// OnWrap, when set, is called with the contents of a line each time the
+	// next word does not fit on it and is moved to a new line.
+	OnWrap func(line string)
+
+	// LineEnding is written at the end of every line. It is "\n" when empty.
+	LineEnding string
+
+	// BreakLongWords splits a word that is wider than Limit over as many
+	// lines as it needs instead of letting it run past the limit.
+	BreakLongWords bool
+}
+
+// Returns the number of columns s takes up using runeWidth to measure each
+// rune, or one column per rune if runeWidth is nil.
+func stringWidth(s string, runeWidth func(r rune) uint) uint {
+	if runeWidth == nil {
+		return uint(utf8.RuneCountInString(s))
+	}
+
+	width := uint(0)
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+
+	return width
 }
 
 func runeToUtf8(r rune) []byte {
@@ -76,6 +113,10 @@ func lableWType(t WordWrapType) string {
 	return "NullUnit"
 }
 
+func (t WordWrapType) String() string {
+	return lableWType(t)
+}
+
 type WrapUnit struct {
 	value []byte
 	typ   WordWrapType
@@ -106,6 +147,25 @@ func (unit WrapUnit) IsNull() bool {
 	return unit.typ == NullUnit
 }
 
+// Value returns a copy of the text of the unit.
+func (unit WrapUnit) Value() []byte {
+	return append([]byte(nil), unit.value...)
+}
+
+// Type returns the kind of the unit.
+func (unit WrapUnit) Type() WordWrapType {
+	return unit.typ
+}
+
+// Width returns the number of columns the unit takes up.
+func (unit WrapUnit) Width() uint {
+	return unit.width
+}
+
+func (unit WrapUnit) String() string {
+	return fmt.Sprintf("%q (%s, width %d)", unit.value, lableWType(unit.typ), unit.width)
+}
+
 var newlineUnit = WrapUnit{typ: NewLine, value: newlineBytes, width: 0}
 var greedyNewlineUnit = WrapUnit{typ: GreedyNewLine, value: newlineBytes, width: 0}
 var nullUnit = WrapUnit{typ: NullUnit}
@@ -123,10 +183,10 @@ func wordToFeed(typ WordWrapType, str string) WrapUnit {
 }
 
 func FeedWordsForWrapping(s string, eater func(unit WrapUnit) uint) {
-	str := ""
+	start := 0
 	var lastWordType WordWrapType
 
-	for _, char := range s {
+	for i, char := range s {
 		var currentWordType WordWrapType
 		if char == '\n' {
 			currentWordType = NewLine
@@ -138,18 +198,16 @@ func FeedWordsForWrapping(s string, eater func(unit WrapUnit) uint) {
 
 		if lastWordType != NullUnit {
 			if lastWordType != currentWordType || char == '\n' {
-				eater(wordToFeed(lastWordType, str))
-				str = ""
+				eater(wordToFeed(lastWordType, s[start:i]))
+				start = i
 			}
 		}
 
-		str += string(char)
-
 		lastWordType = currentWordType
 	}
 
-	if len(str) > 0 {
-		eater(wordToFeed(lastWordType, str))
+	if start < len(s) {
+		eater(wordToFeed(lastWordType, s[start:]))
 	}
 }
 
@@ -225,6 +283,15 @@ type Line struct {
 	limit uint
 }
 
+func (l *Line) reset(start uint, limit uint) {
+	for i := range l.pairs {
+		l.pairs[i] = nil
+	}
+	l.pairs = l.pairs[:0]
+	l.width = start
+	l.limit = limit
+}
+
 func NewLineObject(start uint, limit uint) *Line {
 	return &Line{
 		width: start,
@@ -347,16 +414,73 @@ type WordWrapper struct {
 	currentPair       *UnitPair
 	filledLineLast    bool
 	isInGreedyNewLine bool
+
+	// afterBlock is set when the last unit was a block, and blockEnd is the
+	// width of its last line
+	afterBlock bool
+	blockEnd   uint
+
+	// continuesBlock is set when the current line is written right after the
+	// last line of a block
+	continuesBlock bool
 }
 
 func NewWordWrapper(writer io.Writer, options WrapOptions) *WordWrapper {
-	return &WordWrapper{
+	ww := &WordWrapper{}
+	ww.Reset(writer, options)
+	return ww
+}
+
+// Reset makes the wrapper write to writer with the given options as if it was
+// new, reusing the memory it has already allocated.
+func (ww *WordWrapper) Reset(writer io.Writer, options WrapOptions) {
+	line := ww.currentLine
+	if line == nil {
+		line = NewLineObject(options.StartsAt, options.Limit)
+	} else {
+		line.reset(options.StartsAt, options.Limit)
+	}
+
+	*ww = WordWrapper{
 		WrapOptions:      options,
 		Writer:           writer,
-		indentationBytes: []byte(options.Indentation),
+		indentationBytes: append(ww.indentationBytes[:0], options.Indentation...),
 		lastUnit:         nullUnit,
 		currentPair:      NewUnitPair(true),
-		currentLine:      NewLineObject(options.StartsAt, options.Limit),
+		currentLine:      line,
+	}
+}
+
+var wordWrapperPool = sync.Pool{
+	New: func() any {
+		return &WordWrapper{}
+	},
+}
+
+// Returns a wrapper from the pool reset to write to writer. It should be given
+// back with releaseWordWrapper once it is no longer used.
+func acquireWordWrapper(writer io.Writer, options WrapOptions) *WordWrapper {
+	ww := wordWrapperPool.Get().(*WordWrapper)
+	ww.Reset(writer, options)
+	return ww
+}
+
+func releaseWordWrapper(ww *WordWrapper) {
+	// drop the writer and callbacks so the pool does not keep them alive
+	ww.Reset(nil, WrapOptions{})
+	wordWrapperPool.Put(ww)
+}
+
+// SetLimit changes the column lines are wrapped at, starting with the line
+// being filled. If the words already on that line go past the new limit the
+// line is flushed so they are not lost. The word being built and the spaces
+// before it are not on the line yet, so they are measured against the new
+// limit when the next unit is added or on FinalFlush.
+func (ww *WordWrapper) SetLimit(limit uint) {
+	ww.Limit = limit
+	ww.currentLine.limit = limit
+	if ww.currentLine.NotEmpty() && ww.currentLine.Width() > limit {
+		ww.wrapLine()
 	}
 }
 
@@ -365,6 +489,20 @@ func (ww *WordWrapper) WrapString(s string) {
 	ww.FinalFlush()
 }
 
+// WrapText wraps text into lines of at most width columns the way the contents
+// of a paragraph are wrapped. Every line starts with indent, which is not
+// counted in the width, except the first one when startCol is not zero. The
+// text is then assumed to follow something startCol columns wide that is
+// already on that line.
+func WrapText(text string, width, startCol uint, indent string) string {
+	var b strings.Builder
+	ww := acquireWordWrapper(&b, WrapOptions{Limit: width, StartsAt: startCol, Indentation: indent})
+	ww.WrapString(text)
+	releaseWordWrapper(ww)
+
+	return b.String()
+}
+
 func (ww *WordWrapper) FinalFlush() {
 	if ww.currentPair.HasWord() && !ww.currentLine.IsLastPair(ww.currentPair) {
 		ww.appendPair(ww.currentPair)
@@ -382,12 +520,12 @@ func WordUnit(word string) WrapUnit {
 	return WrapUnit{
 		value: []byte(word),
 		typ:   Word,
-		width: uint(utf8.RuneCountInString(word)),
+		width: stringWidth(word, nil),
 	}
 }
 
 func SpaceUnit(spaces string) WrapUnit {
-	return WrapUnit{value: []byte(spaces), typ: Spaces, width: uint(utf8.RuneCountInString(spaces))}
+	return WrapUnit{value: []byte(spaces), typ: Spaces, width: stringWidth(spaces, nil)}
 }
 
 func (ww *WordWrapper) AddWord(word string) uint {
@@ -406,6 +544,46 @@ func (ww *WordWrapper) AddGreedyNewLine() uint {
 	return ww.AddUnit(greedyNewlineUnit)
 }
 
+// AddBlock adds s, which spans several lines, as is, so line breaks in it are
+// kept and the lines after them are not indented. It is put on lines of its
+// own, after the indentation, unless it follows a word without spaces between
+// them, in which case it continues the line of that word. Likewise a word
+// added right after it continues its last line, and anything else starts a
+// new line.
+func (ww *WordWrapper) AddBlock(s string) {
+	first, _, _ := strings.Cut(s, "\n")
+	unit := WrapUnit{value: []byte(s), typ: Word, width: textWidth(s, ww.RuneWidth, ww.GraphemeWidth)}
+
+	if ww.lastUnit.typ == Word {
+		if !ww.currentLine.Fits(ww.currentPair.Width() + textWidth(first, ww.RuneWidth, ww.GraphemeWidth)) {
+			ww.wrapLine()
+		}
+		ww.currentPair.AddWord(unit)
+		if !ww.currentLine.IsLastPair(ww.currentPair) {
+			ww.appendPair(ww.currentPair)
+		}
+		ww.flushLine()
+	} else {
+		if ww.currentPair.HasWord() && !ww.currentLine.IsLastPair(ww.currentPair) {
+			ww.appendPair(ww.currentPair)
+		}
+		ww.flushLine()
+
+		block := NewUnitPair(ww.lastUnit.typ == NewLine || ww.lastUnit.typ == GreedyNewLine)
+		block.AddWord(unit)
+		ww.appendPair(block)
+		ww.flushLine()
+	}
+
+	last := s[strings.LastIndex(s, "\n")+1:]
+	ww.blockEnd = textWidth(last, ww.RuneWidth, ww.GraphemeWidth)
+	ww.afterBlock = true
+	ww.currentPair = NewUnitPair(false)
+	ww.started = true
+	ww.lastUnit = unit
+	ww.isInGreedyNewLine = false
+}
+
 func unitValues(units []WrapUnit) string {
 	str := ""
 	for _, unit := range units {
@@ -416,8 +594,20 @@ func unitValues(units []WrapUnit) string {
 }
 
 func (ww *WordWrapper) AddUnit(unit WrapUnit) uint {
+	if (ww.RuneWidth != nil || ww.GraphemeWidth) && (unit.typ == Word || unit.typ == Spaces) {
+		unit.width = textWidth(string(unit.value), ww.RuneWidth, ww.GraphemeWidth)
+	}
+
 	aNewLine := !ww.started || ww.lastUnit.typ == NewLine
 
+	if ww.afterBlock {
+		ww.afterBlock = false
+		if unit.typ == Word {
+			ww.continuesBlock = true
+			ww.currentLine.reset(ww.blockEnd, ww.Limit)
+		}
+	}
+
 	switch unit.typ {
 	case NullUnit:
 		return 0
@@ -450,13 +640,13 @@ func (ww *WordWrapper) AddUnit(unit WrapUnit) uint {
 	case Spaces:
 		if ww.lastUnit.typ != Spaces {
 			if !ww.currentLine.PairFits(ww.currentPair) {
-				ww.flushLine()
+				ww.wrapLine()
 			}
 			if ww.currentPair.HasWord() {
 				ww.appendPair(ww.currentPair)
 			}
 			if ww.currentLine.Filled() {
-				ww.flushLine()
+				ww.wrapLine()
 			}
 			ww.currentPair = NewUnitPair(aNewLine)
 			if !ww.isInGreedyNewLine {
@@ -465,10 +655,17 @@ func (ww *WordWrapper) AddUnit(unit WrapUnit) uint {
 		}
 
 	case Word:
+		// Words not separated by spaces, like an overlong URL and the period
+		// after it, are kept in one pair, so wrapping only moves the words
+		// before them and never splits them.
 		ww.isInGreedyNewLine = false
+		if ww.BreakLongWords && unit.width > ww.Limit {
+			ww.addLongWord(unit)
+			break
+		}
 		ww.currentPair.AddWord(unit)
 		if !ww.currentLine.PairFits(ww.currentPair) {
-			ww.flushLine()
+			ww.wrapLine()
 		}
 	}
 
@@ -477,29 +674,110 @@ func (ww *WordWrapper) AddUnit(unit WrapUnit) uint {
 	return 0
 }
 
+// Splits a word wider than the limit, filling the current line and as many
+// lines after it as the word needs. The last piece is left in the current pair
+// so that words following it without spaces stay with it.
+func (ww *WordWrapper) addLongWord(unit WrapUnit) {
+	rest := string(unit.value)
+	for rest != "" {
+		used := ww.currentLine.width + ww.currentPair.Width()
+		if len(ww.currentLine.pairs) == 0 {
+			used = ww.currentLine.width + ww.currentPair.WordWidth()
+		}
+		room := uint(0)
+		if used < ww.currentLine.limit {
+			room = ww.currentLine.limit - used
+		}
+
+		piece, width := ww.prefixWithin(rest, room)
+		if piece == "" {
+			if ww.currentPair.HasWord() {
+				ww.appendPair(ww.currentPair)
+				ww.wrapLine()
+				ww.currentPair = NewUnitPair(false)
+				continue
+			}
+			if ww.currentLine.NotEmpty() {
+				ww.wrapLine()
+				continue
+			}
+
+			// not even one rune fits on an empty line
+			_, size := utf8.DecodeRuneInString(rest)
+			piece = rest[:size]
+			width = textWidth(piece, ww.RuneWidth, ww.GraphemeWidth)
+		}
+
+		ww.currentPair.AddWord(WrapUnit{value: []byte(piece), typ: Word, width: width})
+		rest = rest[len(piece):]
+		if rest == "" {
+			return
+		}
+
+		ww.appendPair(ww.currentPair)
+		ww.wrapLine()
+		ww.currentPair = NewUnitPair(false)
+	}
+}
+
+// Returns the longest prefix of s that is at most room columns wide and its
+// width. Words are only split between runes, and not inside grapheme clusters
+// when measuring by grapheme.
+func (ww *WordWrapper) prefixWithin(s string, room uint) (string, uint) {
+	c := widthCounter{runeWidth: ww.RuneWidth, graphemes: ww.GraphemeWidth}
+	width := uint(0)
+	for i, r := range s {
+		w := c.add(r)
+		if w > 0 && width+w > room {
+			return s[:i], width
+		}
+		width += w
+	}
+
+	return s, width
+}
+
 func (ww *WordWrapper) appendPair(pair *UnitPair) {
 	ww.currentLine.AppendPair(pair)
 }
 
 func (ww *WordWrapper) writeNewLine() {
+	if ww.LineEnding != "" {
+		ww.Writer.Write([]byte(ww.LineEnding))
+		return
+	}
+
 	ww.Writer.Write(newlineBytes)
 }
 
+// Flushes the current line because the next word does not fit on it.
+func (ww *WordWrapper) wrapLine() {
+	if ww.OnWrap != nil && ww.currentLine.NotEmpty() {
+		ww.OnWrap(ww.currentLine.Preview())
+	}
+
+	ww.flushLine()
+}
+
 func (ww *WordWrapper) flushLine() {
 	if !ww.currentLine.NotEmpty() {
 		return
 	}
 
-	if ww.flushed && !ww.currentLine.IsPrecededByNewLine() {
-		ww.writeNewLine()
-	}
+	if ww.continuesBlock {
+		ww.continuesBlock = false
+	} else {
+		if ww.flushed && !ww.currentLine.IsPrecededByNewLine() {
+			ww.writeNewLine()
+		}
 
-	if ww.flushed || ww.StartsAt == 0 {
-		ww.Writer.Write(ww.indentationBytes)
+		if ww.flushed || ww.StartsAt == 0 {
+			ww.Writer.Write(ww.indentationBytes)
+		}
 	}
 	ww.filledLineLast = false
 	ww.currentLine.Write(ww.Writer)
-	ww.currentLine = NewLineObject(0, ww.Limit)
+	ww.currentLine.reset(0, ww.Limit)
 	ww.flushed = true
 }
 
@@ -29,6 +29,7 @@ package formathtml
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
 
@@ -293,6 +294,32 @@ func TestFeedWordsForWrapping(t *testing.T) {
 	}
 }
 
+func TestWrapUnitAccessors(t *testing.T) {
+	tests := []struct {
+		unit     WrapUnit
+		value    string
+		typ      WordWrapType
+		width    uint
+		expected string
+	}{
+		{WordUnit("人間"), "人間", Word, 2, `"人間" (Word, width 2)`},
+		{SpaceUnit("  "), "  ", Spaces, 2, `"  " (Spaces, width 2)`},
+		{newlineUnit, "\n", NewLine, 0, `"\n" (NewLine, width 0)`},
+		{nullUnit, "", NullUnit, 0, `"" (NullUnit, width 0)`},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.value, string(test.unit.Value()))
+		assert.Equal(t, test.typ, test.unit.Type())
+		assert.Equal(t, test.width, test.unit.Width())
+		assert.Equal(t, test.expected, test.unit.String())
+	}
+
+	unit := WordUnit("foo")
+	unit.Value()[0] = 'g'
+	assert.Equal(t, "foo", string(unit.Value()), "changing the value returned does not change the unit")
+}
+
 func TestWordWrapper(t *testing.T) {
 	for i, tc := range cases {
 		buf := bytes.NewBuffer([]byte{})
@@ -315,6 +342,86 @@ func TestWordWrapper(t *testing.T) {
 	}
 }
 
+func TestWrapText(t *testing.T) {
+	for i, tc := range cases {
+		actual := WrapText(tc.Input, tc.Limit, tc.StartsAt, tc.Indentation)
+		assert.Equal(t, tc.Output, actual, "Case %d Input:\n\n`%s`", i, tc.Input)
+	}
+}
+
+func TestWrapTextAltText(t *testing.T) {
+	alt := "A photo of a red fox jumping over a lazy dog in a field"
+	assert.Equal(t, "A photo of a red fox\n    jumping over a lazy dog\n    in a field", WrapText(alt, 24, 4, "    "))
+	assert.Equal(t, "> A photo of a red fox\n> jumping over a lazy dog\n> in a field", WrapText(alt, 24, 0, "> "))
+}
+
+func TestWordWrapperRuneWidth(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	wrapper := NewWordWrapper(buf, WrapOptions{
+		Limit: 5,
+		RuneWidth: func(r rune) uint {
+			if r == '\u4eba' || r == '\u9593' {
+				return 2
+			}
+			return 1
+		},
+	})
+
+	wrapper.WrapString("aa 人間 cc dd")
+
+	assert.Equal(t, "aa\n人間\ncc dd", buf.String())
+}
+
+func TestWordWrapperGraphemeWidth(t *testing.T) {
+	tests := []struct {
+		name      string
+		graphemes bool
+		input     string
+		expected  string
+	}{
+		{
+			name:     "an emoji sequence is measured by its runes by default",
+			input:    "ab \U0001F468\u200d\U0001F469\u200d\U0001F467 cd",
+			expected: "ab \U0001F468\u200d\U0001F469\u200d\U0001F467\ncd",
+		},
+		{
+			name:      "an emoji sequence joined by zero width joiners is one column",
+			graphemes: true,
+			input:     "ab \U0001F468\u200d\U0001F469\u200d\U0001F467 cd",
+			expected:  "ab \U0001F468\u200d\U0001F469\u200d\U0001F467 cd",
+		},
+		{
+			name:      "combining diacritics take up no columns",
+			graphemes: true,
+			input:     "ca\u0301fe\u0301 no\u0308e\u0308l",
+			expected:  "ca\u0301fe\u0301 no\u0308e\u0308l",
+		},
+		{
+			name:      "flags and skin tones are one column",
+			graphemes: true,
+			input:     "\U0001F1EF\U0001F1F5 \U0001F44B\U0001F3FD \U0001F1EB\U0001F1F7 ab",
+			expected:  "\U0001F1EF\U0001F1F5 \U0001F44B\U0001F3FD \U0001F1EB\U0001F1F7 ab",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := bytes.NewBuffer([]byte{})
+			wrapper := NewWordWrapper(buf, WrapOptions{Limit: 10, GraphemeWidth: test.graphemes})
+			wrapper.WrapString(test.input)
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+}
+
+func TestWordWrapperBreakLongWordsKeepsGraphemes(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	wrapper := NewWordWrapper(buf, WrapOptions{Limit: 3, GraphemeWidth: true, BreakLongWords: true})
+	wrapper.WrapString("e\u0301e\u0301e\u0301e\u0301")
+
+	assert.Equal(t, "e\u0301e\u0301e\u0301\ne\u0301", buf.String())
+}
+
 func TestWordWrapperManual(t *testing.T) {
 	buf := bytes.NewBuffer([]byte{})
 	wrapper := NewWordWrapper(buf, WrapOptions{
@@ -361,3 +468,177 @@ func TestWordWrapperManual(t *testing.T) {
 	expected := "aa\nxxbb cc\nxxdd ee\nxxff gg\nxxhhii\nxxjjkkll\nxxmm\nxxnnoo"
 	assert.Equal(t, expected, actual)
 }
+
+func TestWordWrapperOverlongWordWithPunctuation(t *testing.T) {
+	url := "https://example.com/" + strings.Repeat("a", 100)
+	buf := bytes.NewBuffer([]byte{})
+	wrapper := NewWordWrapper(buf, WrapOptions{Limit: 100})
+
+	for _, unit := range []WrapUnit{
+		WordUnit("see"),
+		SpaceUnit(" "),
+		WordUnit(url),
+		WordUnit("."),
+		SpaceUnit(" "),
+		WordUnit("next"),
+	} {
+		wrapper.AddUnit(unit)
+	}
+	wrapper.FinalFlush()
+
+	assert.Equal(t, "see\n"+url+".\nnext", buf.String())
+}
+
+func TestWordWrapperBreakLongWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		options  WrapOptions
+		expected string
+	}{
+		{
+			name:     "long word on its own",
+			input:    "abcdefghijkl",
+			options:  WrapOptions{Limit: 5},
+			expected: "abcde\nfghij\nkl",
+		},
+		{
+			name:     "long word fills the rest of the line",
+			input:    "aa bbbbbbbb cc",
+			options:  WrapOptions{Limit: 5},
+			expected: "aa bb\nbbbbb\nb cc",
+		},
+		{
+			name:     "indented lines",
+			input:    "abcdefghijkl",
+			options:  WrapOptions{Limit: 5, StartsAt: 2, Indentation: "  "},
+			expected: "abc\n  defgh\n  ijkl",
+		},
+		{
+			name:     "multibyte runes",
+			input:    "日本語のテキスト",
+			options:  WrapOptions{Limit: 3},
+			expected: "日本語\nのテキ\nスト",
+		},
+		{
+			name:  "wide runes",
+			input: "日本語のテキスト",
+			options: WrapOptions{
+				Limit: 5,
+				RuneWidth: func(r rune) uint {
+					return 2
+				},
+			},
+			expected: "日本\n語の\nテキ\nスト",
+		},
+		{
+			name:     "short words are not broken",
+			input:    "aaaa bbbbb",
+			options:  WrapOptions{Limit: 5},
+			expected: "aaaa\nbbbbb",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := bytes.NewBuffer([]byte{})
+			test.options.BreakLongWords = true
+			NewWordWrapper(buf, test.options).WrapString(test.input)
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	NewWordWrapper(buf, WrapOptions{Limit: 5}).WrapString("abcdefghijkl")
+	assert.Equal(t, "abcdefghijkl", buf.String(), "long words are kept whole by default")
+}
+
+func TestWordWrapperReset(t *testing.T) {
+	first := bytes.NewBuffer([]byte{})
+	wrapper := NewWordWrapper(first, WrapOptions{Limit: 5, StartsAt: 3, Indentation: "  "})
+	wrapper.WrapString("aa bb cc dd")
+	wrapper.AddWord("ee")
+
+	second := bytes.NewBuffer([]byte{})
+	wrapper.Reset(second, WrapOptions{Limit: 6})
+	wrapper.WrapString("ff gg hh")
+
+	assert.Equal(t, "ff gg\nhh", second.String())
+}
+
+func TestWordWrapperSetLimit(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	wrapper := NewWordWrapper(buf, WrapOptions{Limit: 20})
+	FeedWordsForWrapping("aa bb cc dd ee", wrapper.AddUnit)
+
+	wrapper.SetLimit(5)
+	FeedWordsForWrapping(" ff gg hh", wrapper.AddUnit)
+	wrapper.FinalFlush()
+
+	assert.Equal(t, "aa bb cc dd\nee ff\ngg hh", buf.String())
+}
+
+func TestWordWrapperAddBlock(t *testing.T) {
+	tests := []struct {
+		name     string
+		feed     func(ww *WordWrapper)
+		expected string
+	}{
+		{
+			name: "blocks between spaces are put on lines of their own",
+			feed: func(ww *WordWrapper) {
+				FeedWordsForWrapping("aa ", ww.AddUnit)
+				ww.AddBlock("x\ny")
+				FeedWordsForWrapping(" bb", ww.AddUnit)
+			},
+			expected: "  aa\n  x\ny\n  bb",
+		},
+		{
+			name: "blocks continue the lines of the words they touch",
+			feed: func(ww *WordWrapper) {
+				FeedWordsForWrapping("aa bb", ww.AddUnit)
+				ww.AddBlock("x\ny")
+				FeedWordsForWrapping("cc dd", ww.AddUnit)
+			},
+			expected: "  aa bbx\nycc dd",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := bytes.NewBuffer([]byte{})
+			wrapper := NewWordWrapper(buf, WrapOptions{Limit: 20, Indentation: "  "})
+			test.feed(wrapper)
+			wrapper.FinalFlush()
+
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+}
+
+const benchmarkWrapText = "Lorem ipsum dolor sit amet, consectetur adipiscing elit. Cras in blandit odio, eget gravida eros."
+
+func BenchmarkNewWordWrapper(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wrapper := NewWordWrapper(io.Discard, WrapOptions{Limit: 40, Indentation: "  "})
+		wrapper.WrapString(benchmarkWrapText)
+	}
+}
+
+func BenchmarkPooledWordWrapper(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wrapper := acquireWordWrapper(io.Discard, WrapOptions{Limit: 40, Indentation: "  "})
+		wrapper.WrapString(benchmarkWrapText)
+		releaseWordWrapper(wrapper)
+	}
+}
+
+func BenchmarkFeedWordsForWrappingLongWord(b *testing.B) {
+	word := strings.Repeat("a", 10*1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		FeedWordsForWrapping(word, func(unit WrapUnit) uint { return 0 })
+	}
+}
@@ -0,0 +1,50 @@
+package formathtml
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrWriterClosed is returned when writing to a FormatterWriter that was
+// closed.
+var ErrWriterClosed = errors.New("formathtml: write to closed FormatterWriter")
+
+// FormatterWriter collects the HTML fragment written to it and formats it to
+// its destination when it is closed.
+type FormatterWriter struct {
+	dst    io.Writer
+	opts   FormatOptions
+	buf    bytes.Buffer
+	closed bool
+	err    error
+}
+
+// NewFormatterWriter returns a FormatterWriter that formats what is written to
+// it with the given options and writes the result to dst.
+func NewFormatterWriter(dst io.Writer, opts FormatOptions) *FormatterWriter {
+	return &FormatterWriter{dst: dst, opts: opts}
+}
+
+// Write adds p to the fragment to be formatted.
+func (fw *FormatterWriter) Write(p []byte) (int, error) {
+	if fw.closed {
+		return 0, ErrWriterClosed
+	}
+
+	return fw.buf.Write(p)
+}
+
+// Close formats the fragment that was written and writes it to the
+// destination. Closing again does nothing and returns the same error.
+func (fw *FormatterWriter) Close() error {
+	if fw.closed {
+		return fw.err
+	}
+
+	fw.closed = true
+	fw.err = FragmentWithOptions(fw.dst, &fw.buf, fw.opts)
+	fw.buf = bytes.Buffer{}
+
+	return fw.err
+}
@@ -0,0 +1,47 @@
+package formathtml
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatterWriter(t *testing.T) {
+	var b strings.Builder
+	fw := NewFormatterWriter(&b, DefaultOptions())
+
+	_, err := io.WriteString(fw, "<div><p>Hel")
+	require.NoError(t, err)
+	_, err = io.WriteString(fw, "lo</p></div>")
+	require.NoError(t, err)
+	assert.Equal(t, "", b.String(), "nothing is written before closing")
+
+	require.NoError(t, fw.Close())
+	assert.Equal(t, "<div>\n  <p>Hello</p>\n</div>\n", b.String())
+
+	require.NoError(t, fw.Close())
+	assert.Equal(t, "<div>\n  <p>Hello</p>\n</div>\n", b.String(), "closing again writes nothing")
+
+	_, err = io.WriteString(fw, "<p>more</p>")
+	assert.ErrorIs(t, err, ErrWriterClosed)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestFormatterWriterCloseError(t *testing.T) {
+	fw := NewFormatterWriter(failingWriter{}, DefaultOptions())
+	_, err := io.WriteString(fw, "<p>x</p>")
+	require.NoError(t, err)
+
+	err = fw.Close()
+	assert.EqualError(t, err, "formathtml: render: disk full")
+	assert.Equal(t, err, fw.Close())
+}